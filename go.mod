@@ -4,5 +4,7 @@ go 1.15
 
 require (
 	github.com/golang/protobuf v1.4.3
+	google.golang.org/grpc v1.34.0
 	google.golang.org/protobuf v1.25.0
+	gopkg.in/yaml.v2 v2.4.0
 )
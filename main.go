@@ -1,13 +1,27 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/fusidic/FuCache/pkg/cacheserver"
+	"github.com/fusidic/FuCache/pkg/consistenthash"
+	"github.com/fusidic/FuCache/pkg/discovery"
 	"github.com/fusidic/FuCache/pkg/groupcache"
+	"github.com/fusidic/FuCache/proto/cachepb"
+	"google.golang.org/grpc"
 )
 
 var db = map[string]string{
@@ -27,14 +41,144 @@ func createGroup() *groupcache.Group {
 		}))
 }
 
-// 开启本地节点服务，并将地址填入 Pool，注册到 Group 中
-func startCacheServer(addr string, addrs []string, group *groupcache.Group) {
-	node := cacheserver.NewPool(addr)
-	node.Set(addrs...)
+// discoveryConfig 为节点发现的配置，均为空时使用固定的节点列表
+type discoveryConfig struct {
+	peersFile  string
+	gossipAddr string
+	join       string
+	dnsName    string
+	dnsSRV     bool
+}
+
+// 收到 SIGTERM 后等待处理中请求完成的最长时间，不包括 drainDelay
+const shutdownTimeout = 30 * time.Second
+
+// 开启本地节点服务，并将地址填入 Pool，注册到 Group 中，收到 SIGINT 或 SIGTERM 后优雅关闭
+// peersFile 不为空时，节点列表由该文件提供，并随文件变化而更新；
+// gossipAddr 不为空时，节点通过 gossip 协议互相发现，join 为逗号分隔的种子节点；
+// dnsName 不为空时，节点列表由 DNS 解析得到，A 记录的端口与本节点相同；
+// advertise 不为空时作为本节点在节点列表中的地址 (如 Pod IP)，此时监听所有网卡
+func startCacheServer(addr, advertise string, addrs []string, dc discoveryConfig, drainDelay time.Duration, group *groupcache.Group, opts ...cacheserver.PoolOption) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	listen := u.Host
+	if advertise != "" {
+		listen = ":" + u.Port()
+		if u, err = url.Parse(advertise); err != nil {
+			log.Fatal(err)
+		}
+		addr = advertise
+	}
+	node := cacheserver.NewPool(addr, opts...)
+	server := cacheserver.NewServer(node, listen, cacheserver.WithDrainDelay(drainDelay))
+	switch {
+	case dc.peersFile != "":
+		f := discovery.NewFile(dc.peersFile, 0, node)
+		if err := f.Start(); err != nil {
+			log.Fatal(err)
+		}
+		server.RegisterOnShutdown(f.Stop)
+	case dc.gossipAddr != "":
+		g := discovery.NewGossip(discovery.GossipConfig{Name: addr, BindAddr: dc.gossipAddr}, node)
+		if err := g.Start(); err != nil {
+			log.Fatal(err)
+		}
+		if dc.join != "" {
+			if err := g.Join(strings.Split(dc.join, ",")...); err != nil {
+				log.Fatal(err)
+			}
+		}
+		// 通知其他节点本节点已离开，无需等待故障检测
+		server.RegisterOnShutdown(g.Leave)
+	case dc.dnsName != "":
+		port, _ := strconv.Atoi(u.Port())
+		d := discovery.NewDNS(discovery.DNSConfig{Name: dc.dnsName, SRV: dc.dnsSRV, Port: port, Scheme: u.Scheme}, node)
+		if err := d.Start(); err != nil {
+			log.Fatal(err)
+		}
+		server.RegisterOnShutdown(d.Stop)
+	default:
+		node.Set(addrs...)
+	}
 	// Pool 中有 PickPeer 实现
 	group.RegisterPeers(node)
+	if err := server.Start(); err != nil {
+		log.Fatal(err)
+	}
 	log.Println("groupcache is running at ", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], node))
+
+	select {
+	case err := <-server.Done():
+		log.Fatal(err)
+	case <-shutdownSignal():
+	}
+	log.Println("groupcache is shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), drainDelay+shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("groupcache stopped")
+}
+
+// shutdownSignal 返回的 channel 在收到 SIGINT 或 SIGTERM 时可读
+func shutdownSignal() <-chan os.Signal {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	return sig
+}
+
+// 开启本地 gRPC 节点服务，节点地址为 host:port 形式
+func startGRPCCacheServer(addr string, addrs []string, group *groupcache.Group) {
+	addr = strings.TrimPrefix(addr, "http://")
+	peers := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		peers = append(peers, strings.TrimPrefix(a, "http://"))
+	}
+	node := cacheserver.NewGRPCPool(addr)
+	if err := node.Set(peers...); err != nil {
+		log.Fatal(err)
+	}
+	group.RegisterPeers(node)
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := grpc.NewServer()
+	cachepb.RegisterGroupCacheServer(s, node)
+	log.Println("groupcache(gRPC) is running at ", addr)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- s.Serve(lis) }()
+
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-shutdownSignal():
+	}
+	// 停止接收新请求，等待处理中的请求完成
+	log.Println("groupcache(gRPC) is shutting down")
+	s.GracefulStop()
+	node.Close()
+	log.Println("groupcache(gRPC) stopped")
+}
+
+// 节点间请求签名的密钥，格式为 "id:secret,id2:secret2"，第一个用于签名
+// 通过环境变量传入，避免密钥出现在进程参数中
+const hmacKeysEnv = "FUCACHE_HMAC_KEYS"
+
+func newSigner(keys string) (*cacheserver.Signer, error) {
+	var hmacKeys []cacheserver.HMACKey
+	for _, kv := range strings.Split(keys, ",") {
+		parts := strings.SplitN(kv, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s entry %q, expect id:secret", hmacKeysEnv, kv)
+		}
+		hmacKeys = append(hmacKeys, cacheserver.HMACKey{ID: parts[0], Secret: []byte(parts[1])})
+	}
+	return cacheserver.NewSigner(0, hmacKeys...)
 }
 
 // 用户访问端口
@@ -42,7 +186,8 @@ func startAPIServer(apiAddr string, group *groupcache.Group) {
 	http.Handle("/api", http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
 			key := r.URL.Query().Get("key")
-			view, err := group.Get(key)
+			// 客户端断开连接时取消加载
+			view, err := group.GetContext(r.Context(), key)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -57,8 +202,29 @@ func startAPIServer(apiAddr string, group *groupcache.Group) {
 func main() {
 	var port int
 	var api bool
+	var transport string
+	var placement string
+	var boundedLoad float64
+	var dc discoveryConfig
+	var advertise string
+	var drainDelay time.Duration
+	var tlsConfig cacheserver.TLSConfig
 	flag.IntVar(&port, "port", 8001, "Groupcache server port")
 	flag.BoolVar(&api, "api", false, "Start a api server?")
+	flag.StringVar(&transport, "transport", "http", "Peer transport, http or grpc")
+	flag.StringVar(&placement, "placement", "ring", "Peer placement of http transport, ring or rendezvous")
+	flag.Float64Var(&boundedLoad, "bounded-load", 0, "Load factor of bounded-load consistent hashing for ring placement, 0 to disable")
+	flag.StringVar(&advertise, "advertise-addr", "", "Address of this node as seen by peers of http transport, e.g. http://10.0.0.5:8001, must match the addresses from discovery")
+	flag.StringVar(&dc.peersFile, "peers-file", "", "JSON or YAML membership file of http transport, watched for changes")
+	flag.StringVar(&dc.gossipAddr, "gossip-addr", "", "UDP address for gossip membership of http transport, e.g. 127.0.0.1:7946")
+	flag.StringVar(&dc.join, "join", "", "Comma separated gossip addresses of seed nodes")
+	flag.StringVar(&dc.dnsName, "dns-name", "", "DNS name resolved into peers of http transport, e.g. a headless service")
+	flag.BoolVar(&dc.dnsSRV, "dns-srv", false, "Resolve SRV records instead of A records of -dns-name")
+	flag.DurationVar(&drainDelay, "drain-delay", 15*time.Second, "Time to keep serving after being marked not-ready on shutdown, so that peers stop routing to this node")
+	flag.StringVar(&tlsConfig.CertFile, "tls-cert", "", "Certificate file for TLS between peers of http transport")
+	flag.StringVar(&tlsConfig.KeyFile, "tls-key", "", "Private key file of -tls-cert")
+	flag.StringVar(&tlsConfig.CAFile, "tls-ca", "", "CA file to verify certificates of peers")
+	flag.BoolVar(&tlsConfig.ClientAuth, "mtls", false, "Require client certificates signed by -tls-ca from peers")
 	flag.Parse()
 
 	apiAddr := "http://localhost:9999"
@@ -68,11 +234,19 @@ func main() {
 		8003: "http://localhost:8003",
 	}
 
+	// http 节点间启用 TLS 时节点地址使用 https
+	if transport == "http" && tlsConfig.CertFile != "" {
+		for port, addr := range addrMap {
+			addrMap[port] = "https://" + strings.TrimPrefix(addr, "http://")
+		}
+	}
+
 	// Peer nodes
 	var addrs []string
 	for _, v := range addrMap {
 		addrs = append(addrs, v)
 	}
+	sort.Strings(addrs)
 
 	group := createGroup()
 
@@ -80,5 +254,40 @@ func main() {
 	if api {
 		go startAPIServer(apiAddr, group)
 	}
-	startCacheServer(addrMap[port], []string(addrs), group)
+	switch transport {
+	case "http":
+		var opts []cacheserver.PoolOption
+		switch placement {
+		case "ring":
+		case "rendezvous":
+			opts = append(opts, cacheserver.WithPlacement(func() consistenthash.Placement {
+				return consistenthash.NewRendezvous(nil)
+			}))
+		default:
+			log.Fatalf("unknown placement %q", placement)
+		}
+		if boundedLoad > 0 {
+			opts = append(opts, cacheserver.WithBoundedLoad(boundedLoad))
+		}
+		opts = append(opts, cacheserver.WithHealthCheck(cacheserver.DefaultHealthCheckConfig))
+		if keys := os.Getenv(hmacKeysEnv); keys != "" {
+			signer, err := newSigner(keys)
+			if err != nil {
+				log.Fatal(err)
+			}
+			opts = append(opts, cacheserver.WithSigner(signer))
+		}
+		if tlsConfig.CertFile != "" {
+			t, err := cacheserver.NewTLS(tlsConfig)
+			if err != nil {
+				log.Fatal(err)
+			}
+			opts = append(opts, cacheserver.WithTLS(t))
+		}
+		startCacheServer(addrMap[port], advertise, []string(addrs), dc, drainDelay, group, opts...)
+	case "grpc":
+		startGRPCCacheServer(addrMap[port], []string(addrs), group)
+	default:
+		log.Fatalf("unknown transport %q", transport)
+	}
 }
@@ -0,0 +1,143 @@
+package cacheserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 签名相关的请求头
+const (
+	headerKeyID     = "X-Fucache-Key-Id"
+	headerTimestamp = "X-Fucache-Timestamp"
+	headerSignature = "X-Fucache-Signature"
+)
+
+// 请求时间与本节点时间允许的默认最大偏差
+const defaultSignWindow = 30 * time.Second
+
+// 验证签名时读取的请求体大小上限
+const maxSignedBodySize = 32 << 20
+
+// HMACKey is a shared secret of peers, identified by ID.
+type HMACKey struct {
+	ID     string
+	Secret []byte
+}
+
+// Signer signs requests to peers and verifies requests from peers with HMAC-SHA256
+// over the method, path, query, timestamp and body digest of the request.
+// 签名使用第一个密钥，验证时根据请求中的密钥 ID 选择密钥；轮换密钥时，先在所有节点上
+// 加入新密钥，再将其调整为第一个，最后移除旧密钥，期间请求不会被拒绝
+//
+// 时间戳与本节点时间偏差超过 window 的请求被拒绝，但不记录已处理的请求：
+// 截获的请求在签名后 window 内 (双向偏差，最长 2*window) 可以被原样重放。
+// 节点间的请求均为读取或删除缓存，重放只会造成重复加载或缓存失效，不会写入数据；
+// 如需防止请求被截获，应同时启用 TLS
+type Signer struct {
+	window time.Duration
+
+	mu      sync.RWMutex
+	current HMACKey
+	keys    map[string][]byte
+	// now 用于获取当前时间，测试时可替换
+	now func() time.Time
+}
+
+// NewSigner creates a Signer, window <= 0 means defaultSignWindow.
+func NewSigner(window time.Duration, keys ...HMACKey) (*Signer, error) {
+	if window <= 0 {
+		window = defaultSignWindow
+	}
+	s := &Signer{window: window, now: time.Now}
+	if err := s.SetKeys(keys...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetKeys replaces the keys, the first one is used for signing
+// and all of them are accepted for verification.
+func (s *Signer) SetKeys(keys ...HMACKey) error {
+	if len(keys) == 0 {
+		return errors.New("hmac: at least one key is required")
+	}
+	m := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		if k.ID == "" || len(k.Secret) == 0 {
+			return errors.New("hmac: key ID and secret are required")
+		}
+		m[k.ID] = k.Secret
+	}
+	s.mu.Lock()
+	s.current, s.keys = keys[0], m
+	s.mu.Unlock()
+	return nil
+}
+
+// sign 为请求加上密钥 ID、时间戳与签名，body 为请求体
+func (s *Signer) sign(r *http.Request, body []byte) {
+	s.mu.RLock()
+	key := s.current
+	s.mu.RUnlock()
+	ts := strconv.FormatInt(s.now().Unix(), 10)
+	r.Header.Set(headerKeyID, key.ID)
+	r.Header.Set(headerTimestamp, ts)
+	r.Header.Set(headerSignature, signature(key.Secret, r.Method, r.URL.Path, r.URL.RawQuery, ts, body))
+}
+
+// verify 检查请求的签名与时间戳，请求体被读出后会重新放回 r.Body
+func (s *Signer) verify(r *http.Request) error {
+	id := r.Header.Get(headerKeyID)
+	ts := r.Header.Get(headerTimestamp)
+	sig := r.Header.Get(headerSignature)
+	if id == "" || ts == "" || sig == "" {
+		return errors.New("unsigned request")
+	}
+	s.mu.RLock()
+	secret, ok := s.keys[id]
+	s.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown key %q", id)
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", ts)
+	}
+	if d := s.now().Sub(time.Unix(sec, 0)); d > s.window || d < -s.window {
+		return fmt.Errorf("timestamp outside the %v window", s.window)
+	}
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBodySize+1))
+		if err != nil {
+			return fmt.Errorf("reading body: %v", err)
+		}
+		if len(body) > maxSignedBodySize {
+			return fmt.Errorf("body exceeds %d bytes", maxSignedBodySize)
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	// 使用 hmac.Equal 避免时序攻击
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, r.Method, r.URL.Path, r.URL.RawQuery, ts, body))) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// signature 计算请求的签名，query 为未解码的查询参数，如 group=scores
+func signature(secret []byte, method, path, query, ts string, body []byte) string {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + path + "\n" + query + "\n" + ts + "\n" + hex.EncodeToString(digest[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
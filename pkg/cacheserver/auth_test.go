@@ -0,0 +1,139 @@
+package cacheserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fusidic/FuCache/pkg/groupcache"
+	"github.com/fusidic/FuCache/proto/cachepb"
+)
+
+func TestSignerVerify(t *testing.T) {
+	k1 := HMACKey{ID: "k1", Secret: []byte("secret-1")}
+	k2 := HMACKey{ID: "k2", Secret: []byte("secret-2")}
+	a, err := NewSigner(time.Minute, k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewSigner(time.Minute, k2, k1)
+
+	signed := func(s *Signer, method, path string) *http.Request {
+		r := httptest.NewRequest(method, path, nil)
+		s.sign(r, nil)
+		return r
+	}
+
+	if err := b.verify(signed(a, http.MethodGet, "/_groupcache/scores/Tom")); err != nil {
+		t.Fatalf("valid request rejected: %v", err)
+	}
+
+	r := signed(a, http.MethodGet, "/_groupcache/scores/Tom")
+	r.URL.Path = "/_groupcache/scores/Jack"
+	if err := b.verify(r); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("tampered path should be rejected, got %v", err)
+	}
+	r = signed(a, http.MethodGet, "/_groupcache/_stats?group=scores")
+	r.URL.RawQuery = "group=other"
+	if err := b.verify(r); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("tampered query should be rejected, got %v", err)
+	}
+	r = signed(a, http.MethodGet, "/_groupcache/scores/Tom")
+	r.Method = http.MethodDelete
+	if err := b.verify(r); err == nil {
+		t.Fatalf("tampered method should be rejected")
+	}
+	// 请求体被篡改的请求被拒绝，验证后请求体依旧可读
+	r = httptest.NewRequest(http.MethodPost, "/_groupcache/_batch", strings.NewReader("Tom"))
+	a.sign(r, []byte("Tom"))
+	if err := b.verify(r); err != nil {
+		t.Fatalf("valid request with body rejected: %v", err)
+	}
+	if body, _ := ioutil.ReadAll(r.Body); string(body) != "Tom" {
+		t.Fatalf("body should be readable after verify, got %q", body)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/_groupcache/_batch", strings.NewReader("Jack"))
+	a.sign(r, []byte("Tom"))
+	if err := b.verify(r); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("tampered body should be rejected, got %v", err)
+	}
+	if err := b.verify(httptest.NewRequest(http.MethodGet, "/_groupcache/scores/Tom", nil)); err == nil {
+		t.Fatalf("unsigned request should be rejected")
+	}
+
+	// 超出时间窗口的请求被拒绝
+	r = signed(a, http.MethodGet, "/_groupcache/scores/Tom")
+	b.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if err := b.verify(r); err == nil || !strings.Contains(err.Error(), "window") {
+		t.Fatalf("stale request should be rejected, got %v", err)
+	}
+	b.now = time.Now
+
+	// 轮换密钥：a 改用 k2 签名，b 移除 k1 后 a 的请求依旧有效
+	if err := a.SetKeys(k2, k1); err != nil {
+		t.Fatal(err)
+	}
+	b.SetKeys(k2)
+	if err := b.verify(signed(a, http.MethodGet, "/_groupcache/scores/Tom")); err != nil {
+		t.Fatalf("request signed by new key rejected: %v", err)
+	}
+	old, _ := NewSigner(time.Minute, k1)
+	if err := b.verify(signed(old, http.MethodGet, "/_groupcache/scores/Tom")); err == nil || !strings.Contains(err.Error(), "unknown key") {
+		t.Fatalf("request signed by removed key should be rejected, got %v", err)
+	}
+
+	if _, err := NewSigner(0); err == nil {
+		t.Fatalf("signer without keys should fail")
+	}
+}
+
+func TestPoolSignedRequests(t *testing.T) {
+	groupcache.NewGroup("http-signed", 2<<10, groupcache.GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+	signer, _ := NewSigner(0, HMACKey{ID: "k1", Secret: []byte("secret")})
+
+	srv := httptest.NewServer(NewPool("http://localhost:8001", WithSigner(signer)))
+	defer srv.Close()
+
+	p := NewPool("http://localhost:8002", WithSigner(signer), WithFallbackPeers(0))
+	p.Set(srv.URL)
+	peer, _ := p.PickPeer("Tom")
+	res := &cachepb.Response{}
+	if err := peer.Get(context.Background(), &cachepb.Request{Group: "http-signed", Key: "Tom"}, res); err != nil {
+		t.Fatalf("signed request failed: %v", err)
+	}
+	if string(res.Value) != "630" {
+		t.Fatalf("got %q", res.Value)
+	}
+	if err := peer.Remove(context.Background(), &cachepb.Request{Group: "http-signed", Key: "Tom"}); err != nil {
+		t.Fatalf("signed remove failed: %v", err)
+	}
+	batch := &cachepb.BatchResponse{}
+	req := &cachepb.BatchRequest{Group: "http-signed", Keys: []string{"Tom", "Jack"}}
+	if err := peer.(groupcache.BatchPeerGetter).GetMany(context.Background(), req, batch); err != nil {
+		t.Fatalf("signed batch request failed: %v", err)
+	}
+
+	// 未签名的请求被拒绝，健康检查不需要签名
+	unsigned := NewPool("http://localhost:8002", WithFallbackPeers(0), WithBreaker(BreakerConfig{}))
+	unsigned.Set(srv.URL)
+	peer, _ = unsigned.PickPeer("Tom")
+	err := peer.Get(context.Background(), &cachepb.Request{Group: "http-signed", Key: "Tom"}, &cachepb.Response{})
+	if se, ok := err.(*statusError); !ok || se.code != http.StatusUnauthorized {
+		t.Fatalf("unsigned request should get 401, got %v", err)
+	}
+	res2, err := http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res2.Body.Close()
+	if res2.StatusCode != http.StatusOK {
+		t.Fatalf("healthz returned %d", res2.StatusCode)
+	}
+}
@@ -0,0 +1,204 @@
+package cacheserver
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets all requests through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects all requests until the cool-down is over.
+	BreakerOpen
+	// BreakerHalfOpen lets a single probe request through.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler, used by the stats endpoint.
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// BreakerConfig configures the circuit breaker of each peer.
+// 在 Window 内请求数不少于 MinRequests 且错误率不低于 ErrorRate 时断开，
+// 经过 CoolDown 后进入半开状态，放行一个探测请求，成功则闭合，失败则再次断开
+type BreakerConfig struct {
+	Window      time.Duration
+	MinRequests int
+	ErrorRate   float64 // 不大于 0 时不启用熔断
+	CoolDown    time.Duration
+}
+
+// DefaultBreakerConfig is used by NewPool unless WithBreaker is given.
+var DefaultBreakerConfig = BreakerConfig{
+	Window:      10 * time.Second,
+	MinRequests: 5,
+	ErrorRate:   0.5,
+	CoolDown:    5 * time.Second,
+}
+
+// BreakerStats is a snapshot of a circuit breaker.
+type BreakerStats struct {
+	State    BreakerState `json:"state"`
+	Requests int          `json:"requests"` // 当前窗口内的请求数
+	Failures int          `json:"failures"` // 当前窗口内的失败数
+	Trips    int64        `json:"trips"`    // 累计断开次数
+}
+
+// breaker is a circuit breaker of a peer, safe for concurrent access.
+type breaker struct {
+	mu          sync.Mutex
+	cfg         BreakerConfig
+	state       BreakerState
+	requests    int
+	failures    int
+	trips       int64
+	windowStart time.Time
+	openedAt    time.Time
+	probeAt     time.Time // 半开状态下探测请求的发出时间，零值表示没有探测请求
+	// now 用于获取当前时间，测试时可替换
+	now func() time.Time
+}
+
+func newBreaker(cfg BreakerConfig) *breaker {
+	return &breaker{cfg: cfg, now: time.Now}
+}
+
+// allow reports whether a request can be sent to the peer.
+// 半开状态下只放行一个探测请求；探测请求超过 CoolDown 未返回结果时允许再次探测
+func (b *breaker) allow() bool {
+	if b.cfg.ErrorRate <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.cfg.CoolDown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probeAt = now
+		return true
+	case BreakerHalfOpen:
+		if !b.probeAt.IsZero() && now.Sub(b.probeAt) < b.cfg.CoolDown {
+			return false
+		}
+		b.probeAt = now
+		return true
+	}
+	return true
+}
+
+// rejects reports whether a request would be rejected by allow,
+// without claiming the probe of the half-open state.
+// 用于挑选节点，只有真正发出请求时才调用 allow
+func (b *breaker) rejects() bool {
+	if b.cfg.ErrorRate <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	switch b.state {
+	case BreakerOpen:
+		return now.Sub(b.openedAt) < b.cfg.CoolDown
+	case BreakerHalfOpen:
+		return !b.probeAt.IsZero() && now.Sub(b.probeAt) < b.cfg.CoolDown
+	}
+	return false
+}
+
+// record records the result of a request.
+func (b *breaker) record(failed bool) {
+	if b.cfg.ErrorRate <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.trip(now)
+		} else {
+			b.reset(now, BreakerClosed)
+		}
+		return
+	case BreakerOpen:
+		// 断开前发出的请求，结果不再影响状态
+		return
+	}
+
+	if now.Sub(b.windowStart) >= b.cfg.Window {
+		b.reset(now, BreakerClosed)
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if b.requests >= b.cfg.MinRequests &&
+		float64(b.failures)/float64(b.requests) >= b.cfg.ErrorRate {
+		b.trip(now)
+	}
+}
+
+// abandon records a request canceled by the caller, whose result says nothing about the peer.
+// 不计入统计；半开状态下放弃的是探测请求，清除 probeAt 以便立即发出新的探测
+func (b *breaker) abandon() {
+	if b.cfg.ErrorRate <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.probeAt = time.Time{}
+	}
+}
+
+// trip 断开熔断器，调用方需要持有 mu
+func (b *breaker) trip(now time.Time) {
+	b.trips++
+	b.openedAt = now
+	b.reset(now, BreakerOpen)
+}
+
+// reset 切换状态并开始新的统计窗口，调用方需要持有 mu
+func (b *breaker) reset(now time.Time, state BreakerState) {
+	b.state = state
+	b.requests = 0
+	b.failures = 0
+	b.windowStart = now
+	b.probeAt = time.Time{}
+}
+
+func (b *breaker) stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.state
+	if state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cfg.CoolDown {
+		state = BreakerHalfOpen
+	}
+	return BreakerStats{
+		State:    state,
+		Requests: b.requests,
+		Failures: b.failures,
+		Trips:    b.trips,
+	}
+}
@@ -0,0 +1,95 @@
+package cacheserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(BreakerConfig{
+		Window:      time.Minute,
+		MinRequests: 4,
+		ErrorRate:   0.5,
+		CoolDown:    time.Second,
+	})
+	b.now = func() time.Time { return now }
+
+	b.record(false)
+	b.record(true)
+	b.record(false)
+	if !b.allow() || b.stats().State != BreakerClosed {
+		t.Fatalf("breaker should be closed before MinRequests")
+	}
+	b.record(true)
+	if b.allow() || b.stats().State != BreakerOpen {
+		t.Fatalf("breaker should open when error rate reaches 0.5")
+	}
+
+	// 冷却后只放行一个探测请求
+	now = now.Add(time.Second)
+	if !b.allow() {
+		t.Fatalf("breaker should let a probe through after cool-down")
+	}
+	if b.allow() {
+		t.Fatalf("breaker should let only one probe through")
+	}
+	b.record(true)
+	if b.allow() || b.stats().Trips != 2 {
+		t.Fatalf("failed probe should open the breaker again")
+	}
+
+	now = now.Add(time.Second)
+	if !b.allow() {
+		t.Fatalf("breaker should let a probe through after cool-down")
+	}
+	b.record(false)
+	if !b.allow() || !b.allow() || b.stats().State != BreakerClosed {
+		t.Fatalf("successful probe should close the breaker")
+	}
+}
+
+func TestBreakerAbandon(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(BreakerConfig{
+		Window:      time.Minute,
+		MinRequests: 2,
+		ErrorRate:   0.5,
+		CoolDown:    time.Second,
+	})
+	b.now = func() time.Time { return now }
+
+	// 取消的请求不计入错误率
+	b.record(true)
+	b.abandon()
+	if s := b.stats(); s.State != BreakerClosed || s.Requests != 1 {
+		t.Fatalf("abandoned request should not be counted, got %+v", s)
+	}
+	b.record(true)
+	now = now.Add(time.Second)
+	if !b.allow() {
+		t.Fatalf("breaker should let a probe through after cool-down")
+	}
+
+	// 取消的探测请求不会闭合熔断器，但允许立即发出新的探测
+	b.abandon()
+	if s := b.stats(); s.State != BreakerHalfOpen {
+		t.Fatalf("abandoned probe should keep the breaker half-open, got %v", s.State)
+	}
+	if !b.allow() {
+		t.Fatalf("breaker should let another probe through after the probe was abandoned")
+	}
+	if b.allow() {
+		t.Fatalf("breaker should let only one probe through")
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(BreakerConfig{})
+	for i := 0; i < 10; i++ {
+		b.record(true)
+	}
+	if !b.allow() {
+		t.Fatalf("breaker with zero ErrorRate should never open")
+	}
+}
@@ -0,0 +1,257 @@
+package cacheserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fusidic/FuCache/pkg/consistenthash"
+	"github.com/fusidic/FuCache/pkg/groupcache"
+	"github.com/fusidic/FuCache/proto/cachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultGRPCTimeout 为节点间 gRPC 请求的默认超时时间
+const defaultGRPCTimeout = 5 * time.Second
+
+// GRPCPool implements PeerPicker for a pool of gRPC peers,
+// it is also the GroupCache service in cachepb.proto serving other peers.
+// 与 Pool 不同，节点地址为 host:port 形式，不带 scheme
+type GRPCPool struct {
+	cachepb.UnimplementedGroupCacheServer
+
+	// peer's address, e.g. "example.net:8000"
+	self string
+	// 建立连接时的参数，测试时可传入 bufconn 的 Dialer
+	dialOpts []grpc.DialOption
+	timeout  time.Duration
+	mu       sync.Mutex
+	// consistenthash 中关于 hash、寻址的实现
+	cacheNodes *consistenthash.Map
+	// 各节点名:连接
+	grpcGetter map[string]*grpcGetter
+}
+
+// NewGRPCPool initializes a gRPC pool of peers.
+// 未指定 dialOpts 时默认使用不加密的连接
+func NewGRPCPool(self string, dialOpts ...grpc.DialOption) *GRPCPool {
+	if len(dialOpts) == 0 {
+		dialOpts = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	return &GRPCPool{
+		self:     self,
+		dialOpts: dialOpts,
+		timeout:  defaultGRPCTimeout,
+	}
+}
+
+// Log info with server name
+func (p *GRPCPool) Log(format string, v ...interface{}) {
+	log.Printf("[Server %s] %s", p.self, fmt.Sprintf(format, v...))
+}
+
+// Get implements cachepb.GroupCacheServer.
+func (p *GRPCPool) Get(ctx context.Context, in *cachepb.Request) (*cachepb.Response, error) {
+	p.Log("Get %s/%s", in.GetGroup(), in.GetKey())
+	group, err := lookupGroup(in)
+	if err != nil {
+		return nil, err
+	}
+	group.RecordServerRequest()
+	// 请求来自其他节点，只在本节点处理，不再转发
+	view, err := group.GetContext(groupcache.FromPeer(ctx), in.GetKey())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	res := &cachepb.Response{Value: view.ByteSlice()}
+	if e := view.Expire(); !e.IsZero() {
+		res.Expire = e.UnixNano()
+	}
+	return res, nil
+}
+
+// Remove implements cachepb.GroupCacheServer.
+func (p *GRPCPool) Remove(ctx context.Context, in *cachepb.Request) (*cachepb.Response, error) {
+	p.Log("Remove %s/%s", in.GetGroup(), in.GetKey())
+	group, err := lookupGroup(in)
+	if err != nil {
+		return nil, err
+	}
+	if err := group.RemoveContext(groupcache.FromPeer(ctx), in.GetKey()); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &cachepb.Response{}, nil
+}
+
+// GetMany implements cachepb.GroupCacheServer.
+func (p *GRPCPool) GetMany(ctx context.Context, in *cachepb.BatchRequest) (*cachepb.BatchResponse, error) {
+	p.Log("GetMany %s %d keys", in.GetGroup(), len(in.GetKeys()))
+	group := groupcache.GetGroup(in.GetGroup())
+	if group == nil {
+		return nil, status.Error(codes.NotFound, "no such group "+in.GetGroup())
+	}
+	for range in.GetKeys() {
+		group.RecordServerRequest()
+	}
+	return batchResponse(group.GetManyContext(groupcache.FromPeer(ctx), in.GetKeys())), nil
+}
+
+// lookupGroup 校验请求并返回对应的 group，错误以 gRPC status 的形式返回
+func lookupGroup(in *cachepb.Request) (*groupcache.Group, error) {
+	if in.GetKey() == "" {
+		return nil, status.Error(codes.InvalidArgument, "require a key")
+	}
+	group := groupcache.GetGroup(in.GetGroup())
+	if group == nil {
+		return nil, status.Error(codes.NotFound, "no such group "+in.GetGroup())
+	}
+	return group, nil
+}
+
+type grpcGetter struct {
+	conn    *grpc.ClientConn
+	client  cachepb.GroupCacheClient
+	timeout time.Duration
+}
+
+// Get implements method Get in interface groupcache.PeerGetter
+// ctx 的截止时间由 gRPC 传递给远程节点，timeout 作为上限
+func (g *grpcGetter) Get(ctx context.Context, in *cachepb.Request, out *cachepb.Response) error {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+	res, err := g.client.Get(ctx, in)
+	if err != nil {
+		return grpcError{err}
+	}
+	out.Value = res.GetValue()
+	out.Expire = res.GetExpire()
+	return nil
+}
+
+// GetMany implements method GetMany in interface groupcache.BatchPeerGetter
+func (g *grpcGetter) GetMany(ctx context.Context, in *cachepb.BatchRequest, out *cachepb.BatchResponse) error {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+	res, err := g.client.GetMany(ctx, in)
+	if err != nil {
+		return grpcError{err}
+	}
+	out.Results = res.GetResults()
+	return nil
+}
+
+// Remove implements method Remove in interface groupcache.PeerGetter
+func (g *grpcGetter) Remove(ctx context.Context, in *cachepb.Request) error {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+	if _, err := g.client.Remove(ctx, in); err != nil {
+		return grpcError{err}
+	}
+	return nil
+}
+
+// grpcError wraps errors from peers so that groupcache can tell transient ones.
+type grpcError struct {
+	error
+}
+
+// Temporary reports whether the request may succeed if retried.
+func (e grpcError) Temporary() bool {
+	switch status.Code(e.error) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// GRPCStatus keeps the status of the wrapped error for status.Code.
+func (e grpcError) GRPCStatus() *status.Status {
+	return status.Convert(e.error)
+}
+
+func (e grpcError) Unwrap() error {
+	return e.error
+}
+
+var _ groupcache.BatchPeerGetter = (*grpcGetter)(nil)
+
+// Set updates the pool's list of peers(expect host:port addresses).
+// 仍然存在的节点复用已有连接，被移除的节点会关闭连接
+func (p *GRPCPool) Set(nodes ...string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	getters := make(map[string]*grpcGetter, len(nodes))
+	for _, node := range nodes {
+		if g, ok := p.grpcGetter[node]; ok {
+			getters[node] = g
+			continue
+		}
+		conn, err := grpc.Dial(node, p.dialOpts...)
+		if err != nil {
+			// 关闭本次新建的连接，保持原有状态不变
+			for n, g := range getters {
+				if _, ok := p.grpcGetter[n]; !ok {
+					g.conn.Close()
+				}
+			}
+			return fmt.Errorf("dial peer %s: %v", node, err)
+		}
+		getters[node] = &grpcGetter{
+			conn:    conn,
+			client:  cachepb.NewGroupCacheClient(conn),
+			timeout: p.timeout,
+		}
+	}
+	for node, g := range p.grpcGetter {
+		if _, ok := getters[node]; !ok {
+			g.conn.Close()
+		}
+	}
+	p.cacheNodes = consistenthash.New(defaultReplicas, nil)
+	p.cacheNodes.Add(nodes...)
+	p.grpcGetter = getters
+	return nil
+}
+
+// PickPeer picks a peer according to key.
+func (p *GRPCPool) PickPeer(key string) (groupcache.PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cacheNodes == nil {
+		return nil, false
+	}
+	if peer := p.cacheNodes.Get(key); peer != "" && peer != p.self {
+		p.Log("Pick peer %s", peer)
+		return p.grpcGetter[peer], true
+	}
+	return nil, false
+}
+
+// IsOwner reports whether self owns key.
+func (p *GRPCPool) IsOwner(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cacheNodes == nil {
+		return true
+	}
+	owner := p.cacheNodes.Get(key)
+	return owner == "" || owner == p.self
+}
+
+// Close closes all connections to peers.
+func (p *GRPCPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, g := range p.grpcGetter {
+		g.conn.Close()
+	}
+	p.grpcGetter = nil
+	p.cacheNodes = nil
+	return nil
+}
+
+var _ groupcache.OwnerPicker = (*GRPCPool)(nil)
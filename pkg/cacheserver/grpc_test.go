@@ -0,0 +1,106 @@
+package cacheserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/fusidic/FuCache/pkg/groupcache"
+	"github.com/fusidic/FuCache/proto/cachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var db = map[string]string{
+	"Tom":  "630",
+	"Jack": "589",
+}
+
+// startGRPCServer 在内存中启动 gRPC 节点，返回连接该节点的 DialOption
+func startGRPCServer(t *testing.T, srv *GRPCPool) grpc.DialOption {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	cachepb.RegisterGroupCacheServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.Dial()
+	})
+}
+
+func TestGRPCPool(t *testing.T) {
+	// GetMany 会并发加载多个 key，loadCounts 需要加锁
+	var mu sync.Mutex
+	loadCounts := make(map[string]int)
+	groupcache.NewGroup("grpc", 2<<10, groupcache.GetterFunc(
+		func(key string) ([]byte, error) {
+			mu.Lock()
+			loadCounts[key]++
+			mu.Unlock()
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+
+	dialer := startGRPCServer(t, NewGRPCPool("server"))
+	client := NewGRPCPool("client", dialer, grpc.WithInsecure())
+	if err := client.Set("server"); err != nil {
+		t.Fatalf("failed to set peers: %v", err)
+	}
+	defer client.Close()
+
+	peer, ok := client.PickPeer("Tom")
+	if !ok {
+		t.Fatalf("should pick the server peer")
+	}
+	res := &cachepb.Response{}
+	if err := peer.Get(context.Background(), &cachepb.Request{Group: "grpc", Key: "Tom"}, res); err != nil || string(res.Value) != db["Tom"] {
+		t.Fatalf("failed to get Tom through gRPC: %v", err)
+	}
+	if err := peer.Remove(context.Background(), &cachepb.Request{Group: "grpc", Key: "Tom"}); err != nil {
+		t.Fatalf("failed to remove Tom through gRPC: %v", err)
+	}
+	if err := peer.Get(context.Background(), &cachepb.Request{Group: "grpc", Key: "Tom"}, res); err != nil || loadCounts["Tom"] != 2 {
+		t.Fatalf("removed Tom should be reloaded, loaded %d times", loadCounts["Tom"])
+	}
+
+	batch := &cachepb.BatchResponse{}
+	req := &cachepb.BatchRequest{Group: "grpc", Keys: []string{"Tom", "Jack", "unknown"}}
+	if err := peer.(groupcache.BatchPeerGetter).GetMany(context.Background(), req, batch); err != nil {
+		t.Fatalf("failed to get many through gRPC: %v", err)
+	}
+	if len(batch.Results) != 3 || string(batch.Results[1].Value) != db["Jack"] || batch.Results[2].Error == "" {
+		t.Fatalf("unexpected batch results %v", batch.Results)
+	}
+	if loadCounts["Tom"] != 2 {
+		t.Fatalf("cached Tom should not be reloaded, loaded %d times", loadCounts["Tom"])
+	}
+
+	err := peer.Get(context.Background(), &cachepb.Request{Group: "unknown", Key: "Tom"}, res)
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("unknown group should return NotFound, got %v", err)
+	}
+	err = peer.Get(context.Background(), &cachepb.Request{Group: "grpc", Key: "unknown"}, res)
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("failed load should return Internal, got %v", err)
+	}
+}
+
+func TestGRPCPoolPickSelf(t *testing.T) {
+	p := NewGRPCPool("self")
+	if _, ok := p.PickPeer("Tom"); ok {
+		t.Fatalf("empty pool should not pick any peer")
+	}
+	if err := p.Set("self"); err != nil {
+		t.Fatalf("failed to set peers: %v", err)
+	}
+	defer p.Close()
+	if _, ok := p.PickPeer("Tom"); ok {
+		t.Fatalf("pool should not pick itself")
+	}
+}
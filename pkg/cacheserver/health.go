@@ -0,0 +1,208 @@
+package cacheserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fusidic/FuCache/pkg/groupcache"
+)
+
+// 健康检查的路径，与 metricsPath 一样不在 basePath 之下
+const (
+	healthPath = "/healthz"
+	readyPath  = "/readyz"
+)
+
+// HealthCheckConfig configures active health checking of peers.
+// 每隔 Interval 请求各节点的 /readyz，连续失败 FailureThreshold 次后视为不健康，
+// PickPeer 会跳过不健康的节点；不健康的节点连续成功 SuccessThreshold 次后恢复
+type HealthCheckConfig struct {
+	Interval         time.Duration // 不大于 0 时不启用健康检查
+	Timeout          time.Duration
+	FailureThreshold int
+	SuccessThreshold int
+}
+
+// DefaultHealthCheckConfig is a reasonable configuration for WithHealthCheck.
+var DefaultHealthCheckConfig = HealthCheckConfig{
+	Interval:         5 * time.Second,
+	Timeout:          time.Second,
+	FailureThreshold: 3,
+	SuccessThreshold: 2,
+}
+
+// peerHealth is the health state of a peer, safe for concurrent access.
+type peerHealth struct {
+	mu        sync.Mutex
+	healthy   bool
+	failures  int // 连续失败次数
+	successes int // 不健康时的连续成功次数
+}
+
+func newPeerHealth() *peerHealth {
+	return &peerHealth{healthy: true}
+}
+
+func (h *peerHealth) ok() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.healthy
+}
+
+// record 记录一次探测结果，返回健康状态是否发生变化
+func (h *peerHealth) record(cfg HealthCheckConfig, success bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if success {
+		h.failures = 0
+		if h.healthy {
+			return false
+		}
+		h.successes++
+		if h.successes >= cfg.SuccessThreshold {
+			h.healthy = true
+			return true
+		}
+		return false
+	}
+	h.successes = 0
+	h.failures++
+	if h.healthy && h.failures >= cfg.FailureThreshold {
+		h.healthy = false
+		return true
+	}
+	return false
+}
+
+// serveHealth 只要进程能处理请求即返回 200
+func (p *Pool) serveHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "ok\n")
+}
+
+// serveReady 在节点可以正常服务时返回 200，否则返回 503 及原因
+func (p *Pool) serveReady(w http.ResponseWriter, r *http.Request) {
+	if err := p.Ready(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "ok\n")
+}
+
+// Ready returns nil if the pool is ready to serve,
+// i.e. peers have been registered, at least one group exists and it's not shutting down.
+func (p *Pool) Ready() error {
+	if atomic.LoadInt32(&p.draining) == 1 {
+		return errors.New("shutting down")
+	}
+	p.mu.Lock()
+	peers := len(p.httpGetter)
+	p.mu.Unlock()
+	if peers == 0 {
+		return errors.New("no peers registered")
+	}
+	if len(groupcache.GetGroups()) == 0 {
+		return errors.New("no groups created")
+	}
+	return nil
+}
+
+// drain 将节点标记为正在关闭，其他节点的健康检查会因此跳过本节点
+func (p *Pool) drain() {
+	atomic.StoreInt32(&p.draining, 1)
+}
+
+// PeerHealth returns whether each peer is healthy,
+// all peers are healthy if health checking is disabled.
+func (p *Pool) PeerHealth() map[string]bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	health := make(map[string]bool, len(p.httpGetter))
+	for node, getter := range p.httpGetter {
+		health[node] = getter.health.ok()
+	}
+	return health
+}
+
+// Close stops health checking and closes idle connections to peers.
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closing)
+	})
+	p.healthWg.Wait()
+	if t, ok := p.transport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+}
+
+func (p *Pool) startHealthCheck() {
+	p.healthWg.Add(1)
+	go func() {
+		defer p.healthWg.Done()
+		ticker := time.NewTicker(p.healthConfig.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.closing:
+				return
+			case <-ticker.C:
+				p.checkPeers()
+			}
+		}
+	}()
+}
+
+// checkPeers 并发探测除自身外的所有节点
+func (p *Pool) checkPeers() {
+	p.mu.Lock()
+	getters := p.httpGetter
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for node, getter := range getters {
+		if node == p.self {
+			continue
+		}
+		wg.Add(1)
+		go func(node string, getter *httpGetter) {
+			defer wg.Done()
+			err := p.checkPeer(node)
+			if getter.health.record(p.healthConfig, err == nil) {
+				if err != nil {
+					p.Log("Peer %s is unhealthy: %v", node, err)
+				} else {
+					p.Log("Peer %s is healthy again", node)
+				}
+			}
+		}(node, getter)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) checkPeer(node string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.healthConfig.Timeout)
+	defer cancel()
+	// 使用 /readyz 而非 /healthz，正在关闭的节点也会被视为不健康
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, node+readyPath, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned %v", res.Status)
+	}
+	return nil
+}
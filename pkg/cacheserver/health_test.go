@@ -0,0 +1,105 @@
+package cacheserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fusidic/FuCache/pkg/groupcache"
+)
+
+func TestPoolHealthEndpoints(t *testing.T) {
+	groupcache.NewGroup("http-health", 2<<10, groupcache.GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+
+	p := NewPool("http://localhost:8001")
+	for _, c := range []struct {
+		path string
+		code int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusServiceUnavailable},
+	} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if w.Code != c.code {
+			t.Errorf("%s returned %d, expect %d", c.path, w.Code, c.code)
+		}
+	}
+
+	p.Set("http://localhost:8001", "http://localhost:8002")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("readyz returned %d after peers are set: %s", w.Code, w.Body)
+	}
+}
+
+func TestPeerHealthRecord(t *testing.T) {
+	cfg := HealthCheckConfig{FailureThreshold: 2, SuccessThreshold: 2}
+	h := newPeerHealth()
+	for i, c := range []struct {
+		success bool
+		healthy bool
+		changed bool
+	}{
+		{false, true, false},
+		{true, true, false}, // 成功后重新计数
+		{false, true, false},
+		{false, false, true},
+		{true, false, false},
+		{false, false, false},
+		{true, false, false},
+		{true, true, true},
+	} {
+		if changed := h.record(cfg, c.success); changed != c.changed || h.ok() != c.healthy {
+			t.Fatalf("step %d: healthy %v changed %v, expect %v %v", i, h.ok(), changed, c.healthy, c.changed)
+		}
+	}
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	var down int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/readyz" {
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+		if atomic.LoadInt32(&down) == 1 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	p := NewPool("http://localhost:8001", WithFallbackPeers(0), WithHealthCheck(HealthCheckConfig{
+		Interval:         10 * time.Millisecond,
+		FailureThreshold: 2,
+		SuccessThreshold: 2,
+	}))
+	defer p.Close()
+	p.Set(srv.URL)
+
+	waitPick := func(expect bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, ok := p.PickPeer("Tom"); ok == expect {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("PickPeer should return %v, peer health %v", expect, p.PeerHealth())
+	}
+
+	waitPick(true)
+	atomic.StoreInt32(&down, 1)
+	waitPick(false)
+	if p.PeerHealth()[srv.URL] {
+		t.Fatalf("peer should be unhealthy")
+	}
+	atomic.StoreInt32(&down, 0)
+	waitPick(true)
+}
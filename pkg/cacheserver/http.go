@@ -1,16 +1,24 @@
 package cacheserver
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fusidic/FuCache/pkg/consistenthash"
 	"github.com/fusidic/FuCache/pkg/groupcache"
+	"github.com/fusidic/FuCache/pkg/metrics"
 	"github.com/fusidic/FuCache/proto/cachepb"
 	"google.golang.org/protobuf/proto"
 )
@@ -18,6 +26,22 @@ import (
 const (
 	defaultServerPath = "/_groupcache"
 	defaultReplicas   = 50
+	// 主节点失败时默认尝试的备用节点数
+	defaultFallbacks = 1
+	// 统计信息的路径，位于 basePath 之下，如 /_groupcache/_stats
+	statsPath = "/_stats"
+	// 各节点熔断器状态的路径，如 /_groupcache/_peers
+	peersPath = "/_peers"
+	// 批量获取的路径，请求体为 cachepb.BatchRequest，如 POST /_groupcache/_batch
+	batchPath = "/_batch"
+	// 批量请求的请求体大小上限
+	maxBatchRequestSize = 32 << 20
+	// 节点间请求的默认超时时间
+	defaultRequestTimeout = 5 * time.Second
+	// 每个节点默认保持的空闲连接数
+	defaultMaxIdleConnsPerPeer = 16
+	// 节点响应的默认大小上限
+	defaultMaxResponseSize = 32 << 20
 )
 
 // Pool implements PeerPicker for a pool of HTTP peers.
@@ -29,18 +53,183 @@ type Pool struct {
 	self     string // 记录自己的地址，包括主机名/IP和端口
 	basePath string // Path 前缀，用作区分服务
 	mu       sync.Mutex
-	// consistenthash 中关于 hash、寻址的实现
-	cacheNodes *consistenthash.Map
+	// 串行化节点的增删，重建哈希环时不持有 mu
+	updateMu sync.Mutex
+	// consistenthash 中关于 hash、寻址的实现，默认为哈希环
+	cacheNodes consistenthash.Placement
+	// 创建空的 Placement，用于第一次添加节点时
+	newPlacement func() consistenthash.Placement
+	// 不为 nil 时启用有界负载的一致性哈希
+	loads *consistenthash.Loads
+	// 主节点失败时尝试的备用节点数
+	fallbacks int
+	// 各节点熔断器的配置
+	breakerConfig BreakerConfig
+	// 不为 nil 时节点间通讯使用 TLS，节点地址应为 https://
+	tls *TLS
+	// 不为 nil 时对节点间请求签名，并拒绝 basePath 下未签名的请求
+	signer *Signer
+	// 节点间请求使用的 client，由 transport 与 maxIdleConnsPerPeer 构造
+	client              *http.Client
+	transport           http.RoundTripper
+	maxIdleConnsPerPeer int
+	// 单个请求的超时时间，0 表示只受调用方 ctx 控制
+	requestTimeout time.Duration
+	// 响应大小上限，0 表示不限制
+	maxResponseSize int64
 	// 各节点名:地址
 	httpGetter map[string]*httpGetter
+	// 向各节点请求的耗时，按节点区分
+	clientLatency *metrics.HistogramVec
+	// 处理节点请求的耗时，按 group 区分
+	serverLatency *metrics.HistogramVec
+	// 主动健康检查的配置，Interval 为 0 表示不启用
+	healthConfig HealthCheckConfig
+	// 为 1 时表示节点正在关闭，/readyz 返回 503
+	draining int32
+	// Close 时关闭，停止健康检查
+	closing   chan struct{}
+	closeOnce sync.Once
+	healthWg  sync.WaitGroup
+}
+
+// PoolOption configures a Pool.
+type PoolOption func(*Pool)
+
+// WithPlacement sets the placement algorithm used to pick peers, e.g. consistenthash.NewRendezvous.
+// 节点列表会随节点发现变化，consistenthash.Jump 移除或加入中间的节点会迁移几乎所有 key，不适用于 Pool
+func WithPlacement(fn func() consistenthash.Placement) PoolOption {
+	return func(p *Pool) {
+		p.newPlacement = fn
+	}
+}
+
+// WithBoundedLoad enables consistent hashing with bounded loads,
+// a peer receiving more than factor times the average recent requests is skipped
+// and the key spills to the next peer in the ring.
+// 仅对哈希环（consistenthash.Map）生效
+func WithBoundedLoad(factor float64) PoolOption {
+	return func(p *Pool) {
+		p.loads = consistenthash.NewLoads(factor)
+	}
+}
+
+// WithFallbackPeers sets how many successors of the owner are tried
+// when the owner fails, 0 disables fallback.
+func WithFallbackPeers(n int) PoolOption {
+	return func(p *Pool) {
+		p.fallbacks = n
+	}
+}
+
+// WithBreaker sets the configuration of the circuit breaker of each peer,
+// a zero ErrorRate disables the circuit breaker.
+func WithBreaker(cfg BreakerConfig) PoolOption {
+	return func(p *Pool) {
+		p.breakerConfig = cfg
+	}
+}
+
+// WithHealthCheck enables active health checking of peers,
+// unhealthy peers are skipped by PickPeer until they recover.
+// 零值字段使用 DefaultHealthCheckConfig 中的值
+func WithHealthCheck(cfg HealthCheckConfig) PoolOption {
+	return func(p *Pool) {
+		if cfg.Timeout <= 0 {
+			cfg.Timeout = DefaultHealthCheckConfig.Timeout
+		}
+		if cfg.FailureThreshold <= 0 {
+			cfg.FailureThreshold = DefaultHealthCheckConfig.FailureThreshold
+		}
+		if cfg.SuccessThreshold <= 0 {
+			cfg.SuccessThreshold = DefaultHealthCheckConfig.SuccessThreshold
+		}
+		p.healthConfig = cfg
+	}
+}
+
+// WithTLS enables TLS for peer traffic, requests to peers present the certificate
+// of t and Server serves with it, peers should be given as https:// URLs.
+// 与 WithTransport 同时使用时，需要自行为 transport 设置 t.ClientConfig()
+func WithTLS(t *TLS) PoolOption {
+	return func(p *Pool) {
+		p.tls = t
+	}
+}
+
+// WithSigner enables HMAC signing of requests to peers, requests under the base path
+// without a valid signature are rejected with 401, all peers should share the keys.
+// /metrics、/healthz 与 /readyz 不需要签名
+func WithSigner(s *Signer) PoolOption {
+	return func(p *Pool) {
+		p.signer = s
+	}
+}
+
+// WithTransport sets the http.RoundTripper used for requests to peers,
+// WithMaxIdleConnsPerPeer and the client config of WithTLS are ignored if it's given.
+func WithTransport(rt http.RoundTripper) PoolOption {
+	return func(p *Pool) {
+		p.transport = rt
+	}
+}
+
+// WithRequestTimeout sets the timeout of each request to peers, 0 means no timeout.
+func WithRequestTimeout(d time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.requestTimeout = d
+	}
+}
+
+// WithMaxIdleConnsPerPeer sets the maximum idle (keep-alive) connections to each peer.
+func WithMaxIdleConnsPerPeer(n int) PoolOption {
+	return func(p *Pool) {
+		p.maxIdleConnsPerPeer = n
+	}
+}
+
+// WithMaxResponseSize sets the maximum size of responses from peers, 0 means no limit.
+func WithMaxResponseSize(n int64) PoolOption {
+	return func(p *Pool) {
+		p.maxResponseSize = n
+	}
 }
 
 // NewPool initializes an HTTP pool of peers.
-func NewPool(self string) *Pool {
-	return &Pool{
-		self:     self,
-		basePath: defaultServerPath,
+func NewPool(self string, opts ...PoolOption) *Pool {
+	p := &Pool{
+		self:                self,
+		basePath:            defaultServerPath,
+		fallbacks:           defaultFallbacks,
+		breakerConfig:       DefaultBreakerConfig,
+		requestTimeout:      defaultRequestTimeout,
+		maxIdleConnsPerPeer: defaultMaxIdleConnsPerPeer,
+		maxResponseSize:     defaultMaxResponseSize,
+		newPlacement: func() consistenthash.Placement {
+			return consistenthash.New(defaultReplicas, nil)
+		},
+		clientLatency: metrics.NewHistogramVec("fucache_peer_client_request_duration_seconds",
+			"Latency of requests sent to peers.", "peer", nil),
+		serverLatency: metrics.NewHistogramVec("fucache_peer_server_request_duration_seconds",
+			"Latency of requests served for peers.", "group", nil),
+		closing: make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.transport == nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.MaxIdleConnsPerHost = p.maxIdleConnsPerPeer
+		if p.tls != nil {
+			t.TLSClientConfig = p.tls.ClientConfig()
+		}
+		p.transport = t
+	}
+	p.client = &http.Client{Transport: p.transport}
+	if p.healthConfig.Interval > 0 {
+		p.startHealthCheck()
+	}
+	return p
 }
 
 // Log inof with server name
@@ -50,10 +239,41 @@ func (p *Pool) Log(format string, v ...interface{}) {
 
 // ServeHTTP handle all http requests
 func (p *Pool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !strings.HasPrefix(r.URL.Path, p.basePath) {
-		panic("HTTPPool serving unexpected path: " + r.URL.Path)
+	switch r.URL.Path {
+	case metricsPath:
+		p.serveMetrics(w, r)
+		return
+	case healthPath:
+		p.serveHealth(w, r)
+		return
+	case readyPath:
+		p.serveReady(w, r)
+		return
+	}
+	// Pool 可能挂载在共用的 ServeMux 上，basePath 之外的路径不属于节点间通讯
+	if !strings.HasPrefix(r.URL.Path, p.basePath+"/") {
+		http.NotFound(w, r)
+		return
 	}
 	p.Log("%s %s", r.Method, r.URL.Path)
+	if p.signer != nil {
+		if err := p.signer.verify(r); err != nil {
+			p.Log("Reject request: %v", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	switch r.URL.Path {
+	case p.basePath + statsPath:
+		p.serveStats(w, r)
+		return
+	case p.basePath + peersPath:
+		p.servePeers(w, r)
+		return
+	case p.basePath + batchPath:
+		p.serveBatch(w, r)
+		return
+	}
 	// /<basePath>/<groupName>/<key> required
 	parts := strings.SplitN(r.URL.Path[len(p.basePath)+1:], "/", 2)
 	if len(parts) != 2 {
@@ -71,12 +291,32 @@ func (p *Pool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	view, err := group.Get(key)
+	// 请求来自其他节点，只在本节点处理，不再转发
+	// 请求方断开连接后 r.Context() 会被取消，进而取消加载
+	ctx := groupcache.FromPeer(r.Context())
+
+	// DELETE 请求用于删除缓存
+	if r.Method == http.MethodDelete {
+		if err := group.RemoveContext(ctx, key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	group.RecordServerRequest()
+	defer p.serverLatency.With(groupName).ObserveSince(time.Now())
+	view, err := group.GetContext(ctx, key)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	body, err := proto.Marshal(&cachepb.Response{Value: view.ByteSlice()})
+	res := &cachepb.Response{Value: view.ByteSlice()}
+	if e := view.Expire(); !e.IsZero() {
+		res.Expire = e.UnixNano()
+	}
+	body, err := proto.Marshal(res)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -86,31 +326,204 @@ func (p *Pool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
+// serveStats 以 JSON 形式返回各 group 的统计信息，可通过 ?group=<name> 指定 group
+func (p *Pool) serveStats(w http.ResponseWriter, r *http.Request) {
+	stats := make(map[string]groupcache.Stats)
+	if name := r.URL.Query().Get("group"); name != "" {
+		group := groupcache.GetGroup(name)
+		if group == nil {
+			http.Error(w, "no such group "+name, http.StatusNotFound)
+			return
+		}
+		stats[name] = group.Stats()
+	} else {
+		for name, group := range groupcache.GetGroups() {
+			stats[name] = group.Stats()
+		}
+	}
+	body, err := json.Marshal(stats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// serveBatch 批量获取同一 group 中的多个 key，单个 key 的错误在结果中返回
+func (p *Pool) serveBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBatchRequestSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxBatchRequestSize {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	req := &cachepb.BatchRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group := groupcache.GetGroup(req.GetGroup())
+	if group == nil {
+		http.Error(w, "no such group "+req.GetGroup(), http.StatusNotFound)
+		return
+	}
+
+	for range req.GetKeys() {
+		group.RecordServerRequest()
+	}
+	defer p.serverLatency.With(req.GetGroup()).ObserveSince(time.Now())
+	res := batchResponse(group.GetManyContext(groupcache.FromPeer(r.Context()), req.GetKeys()))
+	out, err := proto.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(out)
+}
+
+// batchResponse 将 GetMany 的结果转换为 cachepb.BatchResponse
+func batchResponse(results []groupcache.Result) *cachepb.BatchResponse {
+	res := &cachepb.BatchResponse{Results: make([]*cachepb.BatchResponse_Result, len(results))}
+	for i, r := range results {
+		result := &cachepb.BatchResponse_Result{}
+		if r.Err != nil {
+			result.Error = r.Err.Error()
+		} else {
+			result.Value = r.Value.ByteSlice()
+			if e := r.Value.Expire(); !e.IsZero() {
+				result.Expire = e.UnixNano()
+			}
+		}
+		res.Results[i] = result
+	}
+	return res
+}
+
+// servePeers 以 JSON 形式返回各节点熔断器的状态
+func (p *Pool) servePeers(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(p.PeerStats())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
 type httpGetter struct {
 	// baseURL 为节点地址
 	baseURL string
+	// 请求耗时
+	latency *metrics.Histogram
+	// 节点故障时熔断，PickPeer 会跳过熔断中的节点，发出请求时再由 allow 放行
+	breaker *breaker
+	// 主动健康检查的结果，PickPeer 会跳过不健康的节点
+	health *peerHealth
+	// 以下均来自 Pool 的配置
+	client          *http.Client
+	timeout         time.Duration
+	maxResponseSize int64
+	signer          *Signer
 }
 
-// Get implements method Get in interface grouphttp.PeerGetter
-func (h *httpGetter) Get(in *cachepb.Request, out *cachepb.Response) error {
-	// 生成完整的 URL
-	u := fmt.Sprintf(
-		"%v%v/%v",
+// errBreakerOpen is returned when the circuit breaker of the peer rejects a request.
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+// statusError is returned when a peer responds with a status other than 200.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "server returned: " + e.status
+}
+
+// Temporary reports whether the request may succeed if retried.
+func (e *statusError) Temporary() bool {
+	return isPeerFailure(e.code)
+}
+
+// isPeerFailure reports whether the status code means the peer itself is unhealthy.
+// 500 可能只是数据源中不存在该 key，只有网关类错误视为节点故障
+func isPeerFailure(code int) bool {
+	return code == http.StatusBadGateway ||
+		code == http.StatusServiceUnavailable ||
+		code == http.StatusGatewayTimeout
+}
+
+// url 生成完整的请求地址
+func (h *httpGetter) url(in *cachepb.Request) string {
+	return fmt.Sprintf(
+		"%v/%v/%v",
 		h.baseURL,
 		url.QueryEscape(in.GetGroup()),
 		url.QueryEscape(in.GetKey()),
 	)
-	res, err := http.Get(u)
+}
+
+// Get implements method Get in interface grouphttp.PeerGetter
+func (h *httpGetter) Get(ctx context.Context, in *cachepb.Request, out *cachepb.Response) error {
+	defer h.latency.ObserveSince(time.Now())
+	ctx, cancel := h.withTimeout(ctx)
+	defer cancel()
+	req, err := h.newRequest(ctx, http.MethodGet, h.url(in), nil)
+	if err != nil {
+		return err
+	}
+	return h.do(ctx, req, out)
+}
+
+// GetMany implements method GetMany in interface groupcache.BatchPeerGetter
+func (h *httpGetter) GetMany(ctx context.Context, in *cachepb.BatchRequest, out *cachepb.BatchResponse) error {
+	defer h.latency.ObserveSince(time.Now())
+	ctx, cancel := h.withTimeout(ctx)
+	defer cancel()
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := h.newRequest(ctx, http.MethodPost, h.baseURL+batchPath, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return h.do(ctx, req, out)
+}
+
+// do 发送请求并将响应解码到 out，同时记录熔断器的结果
+// 熔断器在真正发出请求时才放行，半开状态下的探测请求由此发出并记录结果
+func (h *httpGetter) do(ctx context.Context, req *http.Request, out proto.Message) error {
+	if !h.breaker.allow() {
+		return errBreakerOpen
+	}
+	res, err := h.client.Do(req)
 	if err != nil {
+		// 调用方主动取消 (如对冲请求中较慢的一方) 不能说明节点是否正常，不计入结果
+		if ctx.Err() == context.Canceled {
+			h.breaker.abandon()
+		} else {
+			h.breaker.record(true)
+		}
 		return err
 	}
 	defer res.Body.Close()
+	h.breaker.record(isPeerFailure(res.StatusCode))
 
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
+		return &statusError{code: res.StatusCode, status: res.Status}
 	}
 
-	bytes, err := ioutil.ReadAll(res.Body)
+	bytes, err := h.readBody(res.Body)
 	if err != nil {
 		return fmt.Errorf("reading response body: %v", err)
 	}
@@ -121,28 +534,297 @@ func (h *httpGetter) Get(in *cachepb.Request, out *cachepb.Response) error {
 	return nil
 }
 
+// newRequest 创建请求，启用签名时为其签名
+func (h *httpGetter) newRequest(ctx context.Context, method, url string, body []byte) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return nil, err
+	}
+	if h.signer != nil {
+		h.signer.sign(req, body)
+	}
+	return req, nil
+}
+
+// withTimeout 为请求加上超时，调用方 ctx 的截止时间更早时以其为准
+func (h *httpGetter) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if h.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, h.timeout)
+}
+
+// readBody 读取响应，超过 maxResponseSize 时返回错误
+func (h *httpGetter) readBody(r io.Reader) ([]byte, error) {
+	if h.maxResponseSize <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	bytes, err := ioutil.ReadAll(io.LimitReader(r, h.maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(bytes)) > h.maxResponseSize {
+		return nil, fmt.Errorf("response exceeds %d bytes", h.maxResponseSize)
+	}
+	return bytes, nil
+}
+
+// Remove implements method Remove in interface grouphttp.PeerGetter
+func (h *httpGetter) Remove(ctx context.Context, in *cachepb.Request) error {
+	ctx, cancel := h.withTimeout(ctx)
+	defer cancel()
+	req, err := h.newRequest(ctx, http.MethodDelete, h.url(in), nil)
+	if err != nil {
+		return err
+	}
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
 // 仅传方法过去, 等号后为类型转换
-var _ groupcache.PeerGetter = (*httpGetter)(nil)
+var _ groupcache.BatchPeerGetter = (*httpGetter)(nil)
+
+var _ groupcache.PeerListPicker = (*Pool)(nil)
+
+var _ groupcache.OwnerPicker = (*Pool)(nil)
+
+var _ groupcache.ReplicaPicker = (*Pool)(nil)
+
+// Peer describes a peer and its weight in the hash ring.
+// Weight 为 0 时视为 1，权重越大分到的 key 越多
+type Peer struct {
+	Addr   string // 节点地址，如 "http://localhost:8001"
+	Weight int
+}
 
 // Set updates the pool's list of peers(expect host addresses), which implements peers.PeerPicker interface.
+// 所有节点的权重均为 1
 func (p *Pool) Set(nodes ...string) {
+	p.SetPeers(toPeers(nodes)...)
+}
+
+// SetPeers updates the pool's list of weighted peers.
+// 与当前节点列表比较，只增删有变化的节点，权重变化的节点会被重新加入
+func (p *Pool) SetPeers(peers ...Peer) {
+	p.updateMu.Lock()
+	defer p.updateMu.Unlock()
+	keep := make(map[string]int, len(peers))
+	for _, peer := range peers {
+		keep[peer.Addr] = peerWeight(peer)
+	}
 	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.cacheNodes = consistenthash.New(defaultReplicas, nil)
-	p.cacheNodes.Add(nodes...)
-	p.httpGetter = make(map[string]*httpGetter, len(nodes))
+	var removed []string
+	for node := range p.httpGetter {
+		if weight, ok := keep[node]; !ok || weight != p.cacheNodes.Weight(node) {
+			removed = append(removed, node)
+		}
+	}
+	p.mu.Unlock()
+	p.update(peers, removed)
+}
+
+// AddPeers adds peers to the pool, existing peers are ignored.
+func (p *Pool) AddPeers(peers ...Peer) {
+	p.updateMu.Lock()
+	defer p.updateMu.Unlock()
+	p.update(peers, nil)
+}
+
+// RemovePeers removes peers from the pool, only keys owned by them are moved.
+func (p *Pool) RemovePeers(nodes ...string) {
+	p.updateMu.Lock()
+	defer p.updateMu.Unlock()
+	p.update(nil, nodes)
+}
+
+func toPeers(nodes []string) []Peer {
+	peers := make([]Peer, 0, len(nodes))
 	for _, node := range nodes {
-		p.httpGetter[node] = &httpGetter{baseURL: node + p.basePath}
+		peers = append(peers, Peer{Addr: node, Weight: 1})
+	}
+	return peers
+}
+
+func peerWeight(peer Peer) int {
+	if peer.Weight <= 0 {
+		return 1
+	}
+	return peer.Weight
+}
+
+// PeerStats returns the circuit breaker state of each peer.
+func (p *Pool) PeerStats() map[string]BreakerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make(map[string]BreakerStats, len(p.httpGetter))
+	for node, getter := range p.httpGetter {
+		stats[node] = getter.breaker.stats()
 	}
+	return stats
+}
+
+// Peers returns the current peers of the pool.
+func (p *Pool) Peers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	nodes := make([]string, 0, len(p.httpGetter))
+	for node := range p.httpGetter {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// update 在哈希环的副本上增删节点，完成后再替换，重建期间不持有 mu，
+// PickPeer 可以继续使用旧的哈希环；调用方需要持有 updateMu
+func (p *Pool) update(added []Peer, removed []string) {
+	p.mu.Lock()
+	cacheNodes, getters := p.cacheNodes, p.httpGetter
+	p.mu.Unlock()
+
+	if cacheNodes == nil {
+		cacheNodes = p.newPlacement()
+	} else {
+		cacheNodes = cacheNodes.Clone()
+	}
+	newGetters := make(map[string]*httpGetter, len(getters)+len(added))
+	for node, getter := range getters {
+		newGetters[node] = getter
+	}
+
+	var toRemove []string
+	for _, node := range removed {
+		if _, ok := newGetters[node]; ok {
+			toRemove = append(toRemove, node)
+			delete(newGetters, node)
+			p.clientLatency.Delete(node) // 不再保留已移除节点的延迟序列
+		}
+	}
+	cacheNodes.Remove(toRemove...)
+
+	for _, peer := range added {
+		node := peer.Addr
+		if _, ok := newGetters[node]; ok {
+			continue
+		}
+		cacheNodes.AddWeighted(node, peerWeight(peer))
+		newGetters[node] = &httpGetter{
+			baseURL: node + p.basePath,
+			latency: p.clientLatency.With(node),
+			breaker: newBreaker(p.breakerConfig),
+			health:  newPeerHealth(),
+
+			client:          p.client,
+			timeout:         p.requestTimeout,
+			maxResponseSize: p.maxResponseSize,
+			signer:          p.signer,
+		}
+	}
+
+	p.mu.Lock()
+	p.cacheNodes = cacheNodes
+	p.httpGetter = newGetters
+	p.mu.Unlock()
 }
 
 // PickPeer picks a peer according to key.
+// 熔断中的节点会被跳过，由备用节点代替
 func (p *Pool) PickPeer(key string) (groupcache.PeerGetter, bool) {
+	if peers := p.PickPeers(key); len(peers) > 0 {
+		return peers[0], true
+	}
+	return nil, false
+}
+
+// PickPeers picks the owner of key followed by its successors,
+// the list stops at self since self should load the key locally,
+// unhealthy peers and peers whose circuit breaker is open are skipped.
+func (p *Pool) PickPeers(key string) []groupcache.PeerGetter {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if peer := p.cacheNodes.Get(key); peer != "" && peer != p.self {
-		p.Log("Pick peer %s", peer)
-		return p.httpGetter[peer], true
+	if p.cacheNodes == nil {
+		return nil
 	}
-	return nil, false
+	nodes := []string{p.pick(key)}
+	for _, node := range p.cacheNodes.GetN(key, p.fallbacks+1) {
+		// 有界负载时主节点可能不是环上最近的节点
+		if node != nodes[0] && len(nodes) <= p.fallbacks {
+			nodes = append(nodes, node)
+		}
+	}
+	var (
+		peers  []groupcache.PeerGetter
+		picked []string
+	)
+	for _, node := range nodes {
+		if node == "" || node == p.self {
+			break
+		}
+		getter := p.httpGetter[node]
+		if !getter.health.ok() {
+			p.Log("Skip peer %s, it is unhealthy", node)
+			continue
+		}
+		if getter.breaker.rejects() {
+			p.Log("Skip peer %s, circuit breaker is open", node)
+			continue
+		}
+		peers = append(peers, getter)
+		picked = append(picked, node)
+	}
+	if len(peers) > 0 {
+		p.Log("Pick peers %v", picked)
+	}
+	return peers
+}
+
+// PickReplicas picks the owner of key in the hash ring followed by its successors, except self.
+// 用于删除缓存：不跳过故障或熔断中的节点，也不使用有界负载，因此不会增加负载计数
+func (p *Pool) PickReplicas(key string) []groupcache.PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cacheNodes == nil {
+		return nil
+	}
+	var peers []groupcache.PeerGetter
+	for _, node := range p.cacheNodes.GetN(key, p.fallbacks+1) {
+		if node != p.self {
+			peers = append(peers, p.httpGetter[node])
+		}
+	}
+	return peers
+}
+
+// IsOwner reports whether self owns key, ignoring bounded loads, health and circuit breakers.
+// 与 PickPeers 不同，不会增加节点的负载计数
+func (p *Pool) IsOwner(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cacheNodes == nil {
+		return true
+	}
+	owner := p.cacheNodes.Get(key)
+	return owner == "" || owner == p.self
+}
+
+// pick 返回 key 对应的节点，调用方需要持有 mu
+func (p *Pool) pick(key string) string {
+	if p.loads != nil {
+		if bp, ok := p.cacheNodes.(consistenthash.BoundedPlacement); ok {
+			return bp.GetBounded(key, p.loads)
+		}
+	}
+	return p.cacheNodes.Get(key)
 }
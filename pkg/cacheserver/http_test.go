@@ -0,0 +1,608 @@
+package cacheserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fusidic/FuCache/pkg/consistenthash"
+	"github.com/fusidic/FuCache/pkg/groupcache"
+	"github.com/fusidic/FuCache/proto/cachepb"
+)
+
+func TestPoolStats(t *testing.T) {
+	group := groupcache.NewGroup("http-stats", 2<<10, groupcache.GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+	group.Get("Tom")
+
+	p := NewPool("http://localhost:8001")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_groupcache/_stats?group=http-stats", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("stats returned %d", w.Code)
+	}
+	var stats map[string]groupcache.Stats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("decoding stats: %v", err)
+	}
+	if s := stats["http-stats"]; s.Gets != 1 || s.LocalLoads != 1 || s.MainCache.Items != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_groupcache/_stats?group=unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unknown group should return 404, got %d", w.Code)
+	}
+}
+
+func TestPoolUnexpectedPath(t *testing.T) {
+	p := NewPool("http://localhost:8001")
+	for _, path := range []string{"/", "/favicon.ico", "/_groupcache", "/_groupcachex/scores/Tom"} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s returned %d, want 404", path, w.Code)
+		}
+	}
+}
+
+func TestPoolMetrics(t *testing.T) {
+	groupcache.NewGroup("http-metrics", 2<<10, groupcache.GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+
+	p := NewPool("http://localhost:8001")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_groupcache/http-metrics/Tom", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("get returned %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`fucache_group_gets_total{group="http-metrics"} 1`,
+		`fucache_group_server_requests_total{group="http-metrics"} 1`,
+		`fucache_cache_items{group="http-metrics",cache="main"} 1`,
+		`fucache_peer_server_request_duration_seconds_count{group="http-metrics"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics missing %q", line)
+		}
+	}
+}
+
+func TestPoolRemovedPeerMetrics(t *testing.T) {
+	p := NewPool("http://localhost:8001")
+	p.Set("http://localhost:8001", "http://localhost:8002", "http://localhost:8003")
+	p.RemovePeers("http://localhost:8003")
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `peer="http://localhost:8002"`) {
+		t.Fatalf("metrics missing latency of remaining peer")
+	}
+	if strings.Contains(body, `peer="http://localhost:8003"`) {
+		t.Fatalf("latency of removed peer should be dropped")
+	}
+}
+
+func TestPoolMembership(t *testing.T) {
+	p := NewPool("http://localhost:8001")
+	if _, ok := p.PickPeer("Tom"); ok {
+		t.Fatalf("empty pool should not pick any peer")
+	}
+	p.Set("http://localhost:8001", "http://localhost:8002", "http://localhost:8003")
+
+	owners := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		owners[key] = p.cacheNodes.Get(key)
+	}
+	getter := p.httpGetter["http://localhost:8002"]
+
+	p.RemovePeers("http://localhost:8003")
+	if !reflect.DeepEqual(p.Peers(), []string{"http://localhost:8001", "http://localhost:8002"}) {
+		t.Fatalf("unexpected peers %v", p.Peers())
+	}
+	for key, owner := range owners {
+		if owner != "http://localhost:8003" && p.cacheNodes.Get(key) != owner {
+			t.Fatalf("key %s not owned by removed peer moved", key)
+		}
+	}
+	if p.httpGetter["http://localhost:8002"] != getter {
+		t.Fatalf("getter of unchanged peer should be reused")
+	}
+
+	p.AddPeers(Peer{Addr: "http://localhost:8003"})
+	for key, owner := range owners {
+		if p.cacheNodes.Get(key) != owner {
+			t.Fatalf("key %s should move back to %s", key, owner)
+		}
+	}
+
+	p.Set("http://localhost:8001")
+	if _, ok := p.PickPeer("Tom"); ok {
+		t.Fatalf("pool should not pick itself")
+	}
+}
+
+func TestPoolWeightedPeers(t *testing.T) {
+	p := NewPool("http://localhost:8001")
+	p.SetPeers(
+		Peer{Addr: "http://localhost:8001", Weight: 1},
+		Peer{Addr: "http://localhost:8002", Weight: 4},
+	)
+	if w := p.cacheNodes.Weight("http://localhost:8002"); w != 4 {
+		t.Fatalf("weight of 8002 is %d, expect 4", w)
+	}
+	getter := p.httpGetter["http://localhost:8001"]
+
+	p.SetPeers(
+		Peer{Addr: "http://localhost:8001", Weight: 1},
+		Peer{Addr: "http://localhost:8002", Weight: 2},
+	)
+	if w := p.cacheNodes.Weight("http://localhost:8002"); w != 2 {
+		t.Fatalf("weight of 8002 is %d, expect 2", w)
+	}
+	if p.httpGetter["http://localhost:8001"] != getter {
+		t.Fatalf("getter of unchanged peer should be reused")
+	}
+}
+
+func TestPoolPlacement(t *testing.T) {
+	p := NewPool("http://localhost:8001", WithPlacement(func() consistenthash.Placement {
+		return consistenthash.NewRendezvous(nil)
+	}))
+	p.Set("http://localhost:8001", "http://localhost:8002")
+	if _, ok := p.cacheNodes.(*consistenthash.Rendezvous); !ok {
+		t.Fatalf("placement is %T, expect *consistenthash.Rendezvous", p.cacheNodes)
+	}
+	picked := 0
+	for i := 0; i < 100; i++ {
+		if _, ok := p.PickPeer(strconv.Itoa(i)); ok {
+			picked++
+		}
+	}
+	if picked == 0 || picked == 100 {
+		t.Fatalf("keys should be split between self and peer, picked %d", picked)
+	}
+}
+
+func TestPoolBoundedLoad(t *testing.T) {
+	p := NewPool("http://localhost:8001", WithBoundedLoad(1.25))
+	p.Set("http://localhost:8001", "http://localhost:8002", "http://localhost:8003")
+
+	picked := make(map[groupcache.PeerGetter]int)
+	for i := 0; i < 300; i++ {
+		peer, _ := p.PickPeer("hot")
+		picked[peer]++
+	}
+	// 热点 key 应当分散到自身（nil）与两个远程节点
+	if len(picked) != 3 {
+		t.Fatalf("hot key should spread over all peers, got %v", picked)
+	}
+}
+
+func TestPoolIsOwner(t *testing.T) {
+	p := NewPool("http://localhost:8001", WithBoundedLoad(1.25))
+	p.Set("http://localhost:8001", "http://localhost:8002", "http://localhost:8003")
+
+	owned := 0
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if p.IsOwner(key) {
+			owned++
+		}
+		// 判断拥有者不计入负载
+		for _, node := range []string{"http://localhost:8001", "http://localhost:8002", "http://localhost:8003"} {
+			if load := p.loads.Load(node); load != 0 {
+				t.Fatalf("IsOwner(%s) counted load %v on %s", key, load, node)
+			}
+		}
+	}
+	if owned == 0 || owned == 100 {
+		t.Fatalf("keys should be split between peers, self owns %d", owned)
+	}
+}
+
+func TestPoolPickPeers(t *testing.T) {
+	self := "http://localhost:8001"
+	p := NewPool(self, WithFallbackPeers(2))
+	p.Set("http://localhost:8001", "http://localhost:8002", "http://localhost:8003")
+
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		nodes := p.cacheNodes.GetN(key, 3)
+		peers := p.PickPeers(key)
+		// 列表在遇到自身时截止
+		expect := 0
+		for _, node := range nodes {
+			if node == self {
+				break
+			}
+			if peers[expect] != p.httpGetter[node] {
+				t.Fatalf("key %s: peer %d should be %s", key, expect, node)
+			}
+			expect++
+		}
+		if len(peers) != expect {
+			t.Fatalf("key %s: got %d peers, expect %d", key, len(peers), expect)
+		}
+	}
+}
+
+func TestPoolSkipsOpenBreaker(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	p := NewPool("http://localhost:8001", WithFallbackPeers(0), WithBreaker(BreakerConfig{
+		Window:      time.Minute,
+		MinRequests: 2,
+		ErrorRate:   0.5,
+		CoolDown:    time.Minute,
+	}))
+	p.Set(down.URL)
+
+	for i := 0; i < 2; i++ {
+		peer, ok := p.PickPeer("Tom")
+		if !ok {
+			t.Fatalf("peer should be picked before breaker opens")
+		}
+		if err := peer.Get(context.Background(), &cachepb.Request{Group: "scores", Key: "Tom"}, &cachepb.Response{}); err == nil {
+			t.Fatalf("unavailable peer should return error")
+		}
+	}
+	if _, ok := p.PickPeer("Tom"); ok {
+		t.Fatalf("peer with open breaker should be skipped")
+	}
+	if s := p.PeerStats()[down.URL]; s.State != BreakerOpen || s.Trips != 1 {
+		t.Fatalf("unexpected breaker stats %+v", s)
+	}
+}
+
+func TestPoolCanceledProbeKeepsBreakerOpen(t *testing.T) {
+	// 节点不再响应，请求只会超时或被取消
+	hang := make(chan struct{})
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-hang:
+		}
+	}))
+	defer down.Close()
+	defer close(hang)
+
+	p := NewPool("http://localhost:8001", WithFallbackPeers(0), WithBreaker(BreakerConfig{
+		Window:      time.Minute,
+		MinRequests: 1,
+		ErrorRate:   0.5,
+		CoolDown:    50 * time.Millisecond,
+	}))
+	p.Set(down.URL)
+	get := func(ctx context.Context) {
+		peer, ok := p.PickPeer("Tom")
+		if !ok {
+			t.Fatalf("peer should be picked")
+		}
+		peer.Get(ctx, &cachepb.Request{Group: "scores", Key: "Tom"}, &cachepb.Response{})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	get(ctx)
+	cancel()
+	if s := p.PeerStats()[down.URL]; s.State != BreakerOpen {
+		t.Fatalf("timed out request should open the breaker, got %+v", s)
+	}
+
+	// 冷却后的探测请求被调用方取消 (如对冲请求中较慢的一方)，熔断器不能因此闭合
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	get(ctx)
+	if s := p.PeerStats()[down.URL]; s.State != BreakerHalfOpen || s.Trips != 1 {
+		t.Fatalf("canceled probe should keep the breaker half-open, got %+v", s)
+	}
+	if _, ok := p.PickPeer("Tom"); !ok {
+		t.Fatalf("another probe should be allowed after the probe was canceled")
+	}
+}
+
+func TestPoolRemoveReachesOwner(t *testing.T) {
+	var deletes int32
+	var deleteStatus int32 = http.StatusOK
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			atomic.AddInt32(&deletes, 1)
+			w.WriteHeader(int(atomic.LoadInt32(&deleteStatus)))
+			return
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer owner.Close()
+
+	p := NewPool("http://localhost:8001", WithFallbackPeers(0), WithBoundedLoad(1.25), WithBreaker(BreakerConfig{
+		Window:      time.Minute,
+		MinRequests: 1,
+		ErrorRate:   0.5,
+		CoolDown:    time.Minute,
+	}))
+	p.Set("http://localhost:8001", owner.URL)
+	group := groupcache.NewGroup("http-remove-owner", 2<<10, groupcache.GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	group.RegisterPeers(p)
+	key := ""
+	for i := 0; key == ""; i++ {
+		if k := strconv.Itoa(i); !p.IsOwner(k) {
+			key = k
+		}
+	}
+
+	// 拥有者熔断后 Get 由本节点加载，删除依旧需要通知拥有者
+	peer, _ := p.PickPeer(key)
+	peer.Get(context.Background(), &cachepb.Request{Group: "http-remove-owner", Key: key}, &cachepb.Response{})
+	if _, ok := p.PickPeer(key); ok {
+		t.Fatalf("owner with open breaker should be skipped by Get")
+	}
+	load := p.loads.Load(owner.URL) + p.loads.Load("http://localhost:8001")
+	if err := group.Remove(key); err != nil || atomic.LoadInt32(&deletes) != 1 {
+		t.Fatalf("Remove should reach the owner, got %v and %d deletes", err, deletes)
+	}
+	if l := p.loads.Load(owner.URL) + p.loads.Load("http://localhost:8001"); l > load {
+		t.Fatalf("Remove should not count bounded loads, %v before and %v after", load, l)
+	}
+
+	// 拥有者删除失败时返回错误
+	atomic.StoreInt32(&deleteStatus, http.StatusInternalServerError)
+	if err := group.Remove(key); err == nil {
+		t.Fatalf("Remove should fail when the owner fails")
+	}
+}
+
+func TestPoolPickDoesNotClaimProbe(t *testing.T) {
+	var failing int32 = 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte{})
+	}))
+	defer srv.Close()
+
+	p := NewPool("http://localhost:8001", WithFallbackPeers(0), WithBreaker(BreakerConfig{
+		Window:      time.Minute,
+		MinRequests: 2,
+		ErrorRate:   0.5,
+		CoolDown:    20 * time.Millisecond,
+	}))
+	p.Set(srv.URL)
+	for i := 0; i < 2; i++ {
+		peer, _ := p.PickPeer("Tom")
+		peer.Get(context.Background(), &cachepb.Request{Group: "scores", Key: "Tom"}, &cachepb.Response{})
+	}
+	if s := p.PeerStats()[srv.URL]; s.Trips != 1 {
+		t.Fatalf("breaker should be open, got %+v", s)
+	}
+
+	// 冷却结束后多次挑选节点不会占用探测请求，真正发出的请求作为探测并闭合熔断器
+	atomic.StoreInt32(&failing, 0)
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if peers := p.PickPeers("Tom"); len(peers) != 1 {
+			t.Fatalf("pick %d: half-open peer should be picked", i)
+		}
+	}
+	peer, _ := p.PickPeer("Tom")
+	if err := peer.Get(context.Background(), &cachepb.Request{Group: "scores", Key: "Tom"}, &cachepb.Response{}); err != nil {
+		t.Fatalf("probe request failed: %v", err)
+	}
+	if s := p.PeerStats()[srv.URL]; s.State != BreakerClosed {
+		t.Fatalf("successful probe should close the breaker, got %+v", s)
+	}
+}
+
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestPoolHTTPClientOptions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_groupcache/scores/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/_groupcache/scores/big":
+			w.Write(make([]byte, 1024))
+		}
+	}))
+	defer srv.Close()
+
+	transport := &countingTransport{}
+	p := NewPool("http://localhost:8001",
+		WithTransport(transport),
+		WithRequestTimeout(50*time.Millisecond),
+		WithMaxResponseSize(512),
+		WithBreaker(BreakerConfig{}),
+	)
+	p.Set(srv.URL)
+	peer, _ := p.PickPeer("Tom")
+
+	err := peer.Get(context.Background(), &cachepb.Request{Group: "scores", Key: "slow"}, &cachepb.Response{})
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Fatalf("slow request should time out, got %v", err)
+	}
+	err = peer.Get(context.Background(), &cachepb.Request{Group: "scores", Key: "big"}, &cachepb.Response{})
+	if err == nil || !strings.Contains(err.Error(), "exceeds 512 bytes") {
+		t.Fatalf("big response should be rejected, got %v", err)
+	}
+	if transport.requests != 2 {
+		t.Fatalf("custom transport should be used, got %d requests", transport.requests)
+	}
+}
+
+func TestPoolBatch(t *testing.T) {
+	groupcache.NewGroup("http-batch", 2<<10, groupcache.GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+	srv := httptest.NewServer(NewPool("http://localhost:8001"))
+	defer srv.Close()
+
+	p := NewPool("http://localhost:8002", WithFallbackPeers(0))
+	p.Set(srv.URL)
+	peer, _ := p.PickPeer("Tom")
+	res := &cachepb.BatchResponse{}
+	req := &cachepb.BatchRequest{Group: "http-batch", Keys: []string{"Tom", "unknown", "Jack"}}
+	if err := peer.(groupcache.BatchPeerGetter).GetMany(context.Background(), req, res); err != nil {
+		t.Fatalf("batch request failed: %v", err)
+	}
+	if len(res.Results) != 3 {
+		t.Fatalf("got %d results, expect 3", len(res.Results))
+	}
+	if string(res.Results[0].Value) != "630" || string(res.Results[2].Value) != "589" {
+		t.Fatalf("unexpected results %v", res.Results)
+	}
+	if !strings.Contains(res.Results[1].Error, "not exist") {
+		t.Fatalf("error of unknown key should be returned per key, got %q", res.Results[1].Error)
+	}
+
+	// 批量请求只接受 POST
+	resp, err := http.Get(srv.URL + defaultServerPath + batchPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("GET batch returned %d", resp.StatusCode)
+	}
+}
+
+func TestPoolServesPeerRequestsLocally(t *testing.T) {
+	var ownerRequests int32
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&ownerRequests, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer owner.Close()
+
+	group := groupcache.NewGroup("http-from-peer", 2<<10, groupcache.GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+	// replica 认为 owner 拥有所有 key
+	replica := NewPool("http://localhost:8002", WithFallbackPeers(0), WithBreaker(BreakerConfig{}))
+	replica.Set(owner.URL)
+	group.RegisterPeers(replica)
+	srv := httptest.NewServer(replica)
+	defer srv.Close()
+
+	// 其他节点回退到 replica 的请求在 replica 本地加载，不会被转发回 owner
+	res, err := http.Get(srv.URL + defaultServerPath + "/http-from-peer/Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("request from peer returned %d", res.StatusCode)
+	}
+	if n := atomic.LoadInt32(&ownerRequests); n != 0 {
+		t.Fatalf("request from peer should not be forwarded, owner got %d requests", n)
+	}
+
+	// 本节点自身的请求依旧发送给 owner
+	group.Get("Jack")
+	if n := atomic.LoadInt32(&ownerRequests); n != 1 {
+		t.Fatalf("local request should be sent to the owner, owner got %d requests", n)
+	}
+}
+
+func TestPoolHedgeRoutesAroundSlowOwner(t *testing.T) {
+	var ownerRequests int32
+	release := make(chan struct{})
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&ownerRequests, 1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer owner.Close()
+	defer close(release)
+
+	getter := groupcache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	})
+	group := groupcache.NewGroup("http-hedge", 2<<10, getter,
+		groupcache.WithHedging(groupcache.HedgeConfig{Delay: 20 * time.Millisecond, BudgetRatio: 1}))
+	// 同一进程中以另一个 group 模拟 replica 节点，它同样认为 owner 拥有该 key，
+	// 收到的请求若被转发，会再次发给 owner
+	replicaGroup := groupcache.NewGroup("http-hedge-replica", 2<<10, getter)
+	replicaPool := NewPool("http://localhost:8003")
+	replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = strings.Replace(r.URL.Path, "/http-hedge/", "/http-hedge-replica/", 1)
+		replicaPool.ServeHTTP(w, r)
+	}))
+	defer replica.Close()
+
+	p := NewPool("http://localhost:8001", WithFallbackPeers(1))
+	p.Set(owner.URL, replica.URL)
+	group.RegisterPeers(p)
+	replicaGroup.RegisterPeers(p)
+	key := ""
+	for i := 0; key == ""; i++ {
+		k := "key" + strconv.Itoa(i)
+		if peers := p.PickPeers(k); len(peers) == 2 && strings.HasPrefix(peers[0].(*httpGetter).baseURL, owner.URL) {
+			key = k
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := group.Get(key)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("hedged get failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("hedged request should not wait for the slow owner")
+	}
+	if n := atomic.LoadInt32(&ownerRequests); n != 1 {
+		t.Fatalf("hedged request should not be forwarded to the owner, owner got %d requests", n)
+	}
+	if s := group.Stats(); s.PeerHedges != 1 || s.PeerLoads != 1 || s.LocalLoads != 0 {
+		t.Fatalf("hedge to the replica should win, got stats %+v", s)
+	}
+	if s := replicaGroup.Stats(); s.LocalLoads != 1 || s.PeerLoads != 0 {
+		t.Fatalf("replica should load locally, got stats %+v", s)
+	}
+}
@@ -0,0 +1,142 @@
+package cacheserver
+
+import (
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/fusidic/FuCache/pkg/groupcache"
+	"github.com/fusidic/FuCache/pkg/metrics"
+)
+
+// metricsPath 为 Prometheus 抓取的路径，不在 basePath 之下
+const metricsPath = "/metrics"
+
+// groupCounter 描述一个由 groupcache.Stats 导出的计数器
+type groupCounter struct {
+	name  string
+	help  string
+	value func(s groupcache.Stats) int64
+}
+
+var groupCounters = []groupCounter{
+	{"fucache_group_gets_total", "Get requests, including requests from peers.", func(s groupcache.Stats) int64 { return s.Gets }},
+	{"fucache_group_cache_hits_total", "Get requests served from the main or hot cache.", func(s groupcache.Stats) int64 { return s.CacheHits }},
+	{"fucache_group_cache_misses_total", "Get requests missing both caches.", func(s groupcache.Stats) int64 { return s.Loads }},
+	{"fucache_group_loads_deduped_total", "Loads executed after singleflight deduplication.", func(s groupcache.Stats) int64 { return s.LoadsDeduped }},
+	{"fucache_group_peer_loads_total", "Successful loads from peers.", func(s groupcache.Stats) int64 { return s.PeerLoads }},
+	{"fucache_group_peer_errors_total", "Failed loads from peers.", func(s groupcache.Stats) int64 { return s.PeerErrors }},
+	{"fucache_group_peer_hedges_total", "Hedged requests sent to peers.", func(s groupcache.Stats) int64 { return s.PeerHedges }},
+	{"fucache_group_peer_retries_total", "Requests to peers retried after transient errors.", func(s groupcache.Stats) int64 { return s.PeerRetries }},
+	{"fucache_group_local_loads_total", "Successful loads from the Getter.", func(s groupcache.Stats) int64 { return s.LocalLoads }},
+	{"fucache_group_local_load_errors_total", "Failed loads from the Getter.", func(s groupcache.Stats) int64 { return s.LocalLoadErrs }},
+	{"fucache_group_local_batches_total", "Calls of BatchGetter.GetMany.", func(s groupcache.Stats) int64 { return s.LocalBatches }},
+	{"fucache_group_refreshes_total", "Background reloads of values about to expire.", func(s groupcache.Stats) int64 { return s.Refreshes }},
+	{"fucache_group_stale_hits_total", "Stale values served after failed loads.", func(s groupcache.Stats) int64 { return s.StaleHits }},
+	{"fucache_group_server_requests_total", "Get requests that came over the network from peers.", func(s groupcache.Stats) int64 { return s.ServerRequests }},
+}
+
+// cacheMetric 描述一个由 groupcache.CacheStats 导出的指标，按 main/hot/stale 区分
+type cacheMetric struct {
+	name  string
+	help  string
+	typ   string
+	value func(s groupcache.CacheStats) int64
+}
+
+var cacheMetrics = []cacheMetric{
+	{"fucache_cache_bytes", "Bytes used by keys and values in the cache.", "gauge", func(s groupcache.CacheStats) int64 { return s.Bytes }},
+	{"fucache_cache_items", "Items in the cache.", "gauge", func(s groupcache.CacheStats) int64 { return s.Items }},
+	{"fucache_cache_gets_total", "Lookups in the cache.", "counter", func(s groupcache.CacheStats) int64 { return s.Gets }},
+	{"fucache_cache_hits_total", "Lookups hitting the cache.", "counter", func(s groupcache.CacheStats) int64 { return s.Hits }},
+	{"fucache_cache_evictions_total", "Items evicted from the cache due to capacity.", "counter", func(s groupcache.CacheStats) int64 { return s.Evictions }},
+	{"fucache_cache_expirations_total", "Expired items removed from the cache.", "counter", func(s groupcache.CacheStats) int64 { return s.Expirations }},
+}
+
+// writeGroupMetrics renders the statistics of all groups in the text exposition format.
+func writeGroupMetrics(w io.Writer) {
+	groups := groupcache.GetGroups()
+	names := make([]string, 0, len(groups))
+	stats := make(map[string]groupcache.Stats, len(groups))
+	for name, g := range groups {
+		names = append(names, name)
+		stats[name] = g.Stats()
+	}
+	sort.Strings(names)
+
+	for _, c := range groupCounters {
+		metrics.WriteHeader(w, c.name, c.help, "counter")
+		for _, name := range names {
+			labels := []metrics.Label{{Name: "group", Value: name}}
+			metrics.WriteSample(w, c.name, labels, float64(c.value(stats[name])))
+		}
+	}
+	for _, m := range cacheMetrics {
+		metrics.WriteHeader(w, m.name, m.help, m.typ)
+		for _, name := range names {
+			s := stats[name]
+			for _, c := range []struct {
+				typ   string
+				stats groupcache.CacheStats
+			}{{"main", s.MainCache}, {"hot", s.HotCache}, {"stale", s.StaleCache}} {
+				labels := []metrics.Label{{Name: "group", Value: name}, {Name: "cache", Value: c.typ}}
+				metrics.WriteSample(w, m.name, labels, float64(m.value(c.stats)))
+			}
+		}
+	}
+}
+
+// writeBreakerMetrics renders the circuit breaker of each peer.
+// 每个节点的每种状态输出一个样本，当前状态为 1，其余为 0
+func writeBreakerMetrics(w io.Writer, stats map[string]BreakerStats) {
+	peers := make([]string, 0, len(stats))
+	for peer := range stats {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+
+	metrics.WriteHeader(w, "fucache_peer_breaker_state", "Circuit breaker state of peers.", "gauge")
+	for _, peer := range peers {
+		for _, state := range []BreakerState{BreakerClosed, BreakerOpen, BreakerHalfOpen} {
+			var value float64
+			if stats[peer].State == state {
+				value = 1
+			}
+			labels := []metrics.Label{{Name: "peer", Value: peer}, {Name: "state", Value: state.String()}}
+			metrics.WriteSample(w, "fucache_peer_breaker_state", labels, value)
+		}
+	}
+	metrics.WriteHeader(w, "fucache_peer_breaker_trips_total", "Times the circuit breaker of peers opened.", "counter")
+	for _, peer := range peers {
+		labels := []metrics.Label{{Name: "peer", Value: peer}}
+		metrics.WriteSample(w, "fucache_peer_breaker_trips_total", labels, float64(stats[peer].Trips))
+	}
+}
+
+// writeHealthMetrics renders the result of active health checking of each peer.
+func writeHealthMetrics(w io.Writer, health map[string]bool) {
+	peers := make([]string, 0, len(health))
+	for peer := range health {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+
+	metrics.WriteHeader(w, "fucache_peer_healthy", "Whether peers passed active health checks.", "gauge")
+	for _, peer := range peers {
+		var value float64
+		if health[peer] {
+			value = 1
+		}
+		metrics.WriteSample(w, "fucache_peer_healthy", []metrics.Label{{Name: "peer", Value: peer}}, value)
+	}
+}
+
+// serveMetrics 返回 Prometheus 文本格式的指标
+func (p *Pool) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeGroupMetrics(w)
+	writeBreakerMetrics(w, p.PeerStats())
+	writeHealthMetrics(w, p.PeerHealth())
+	p.clientLatency.Write(w)
+	p.serverLatency.Write(w)
+}
@@ -0,0 +1,126 @@
+package cacheserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Server serves a Pool over HTTP and shuts it down gracefully.
+// Shutdown 依次执行：标记为未就绪 (/readyz 返回 503)，执行 RegisterOnShutdown 注册的函数
+// (如通过 gossip 通知其他节点离开)，等待 drainDelay 让其他节点与负载均衡器摘除本节点，
+// 停止接收新请求并等待处理中的请求 (包括其触发的加载) 完成，最后关闭 Pool
+type Server struct {
+	pool       *Pool
+	srv        *http.Server
+	lis        net.Listener
+	drainDelay time.Duration
+
+	mu       sync.Mutex
+	onShut   []func()
+	serveErr chan error
+}
+
+// ServerOption configures a Server.
+type ServerOption func(*Server)
+
+// WithDrainDelay sets how long the server keeps serving after it is marked not-ready,
+// so that peers and load balancers stop routing to it before it stops accepting requests.
+func WithDrainDelay(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.drainDelay = d
+	}
+}
+
+// WithHTTPServer sets the http.Server used to serve the pool, its Handler is replaced by the pool.
+// 可用于设置 ReadTimeout 等参数
+func WithHTTPServer(srv *http.Server) ServerOption {
+	return func(s *Server) {
+		s.srv = srv
+	}
+}
+
+// NewServer creates a Server listening on addr (host:port) for the pool.
+func NewServer(pool *Pool, addr string, opts ...ServerOption) *Server {
+	s := &Server{
+		pool:     pool,
+		srv:      &http.Server{},
+		serveErr: make(chan error, 1),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.srv.Addr = addr
+	s.srv.Handler = pool
+	return s
+}
+
+// Start listens on the address and serves in background,
+// with TLS if the pool is created with WithTLS.
+func (s *Server) Start() error {
+	lis, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return err
+	}
+	if s.pool.tls != nil {
+		lis = tls.NewListener(lis, s.pool.tls.ServerConfig())
+	}
+	s.lis = lis
+	go func() {
+		err := s.srv.Serve(lis)
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+		s.serveErr <- err
+	}()
+	return nil
+}
+
+// Addr returns the address the server listens on, nil before Start.
+func (s *Server) Addr() net.Addr {
+	if s.lis == nil {
+		return nil
+	}
+	return s.lis.Addr()
+}
+
+// Done returns a channel which receives the error of serving once the server stops,
+// nil if it was stopped by Shutdown.
+func (s *Server) Done() <-chan error {
+	return s.serveErr
+}
+
+// RegisterOnShutdown registers a function to call at the beginning of Shutdown,
+// e.g. to announce departure to peers.
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onShut = append(s.onShut, f)
+}
+
+// Shutdown gracefully shuts down the server, in-flight requests are finished
+// unless ctx is done first, in which case ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.pool.drain()
+	s.mu.Lock()
+	hooks := s.onShut
+	s.mu.Unlock()
+	for _, f := range hooks {
+		f()
+	}
+
+	if s.drainDelay > 0 {
+		s.pool.Log("Draining for %v", s.drainDelay)
+		select {
+		case <-time.After(s.drainDelay):
+		case <-ctx.Done():
+		}
+	}
+	err := s.srv.Shutdown(ctx)
+	s.pool.Close()
+	return err
+}
@@ -0,0 +1,98 @@
+package cacheserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fusidic/FuCache/pkg/groupcache"
+)
+
+func TestServerShutdown(t *testing.T) {
+	loading := make(chan struct{})
+	release := make(chan struct{})
+	groupcache.NewGroup("http-shutdown", 2<<10, groupcache.GetterFunc(
+		func(key string) ([]byte, error) {
+			close(loading)
+			<-release
+			return []byte("630"), nil
+		}))
+
+	pool := NewPool("http://127.0.0.1:0")
+	pool.Set("http://127.0.0.1:0")
+	s := NewServer(pool, "127.0.0.1:0", WithDrainDelay(100*time.Millisecond))
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	base := "http://" + s.Addr().String()
+	departed := make(chan struct{})
+	s.RegisterOnShutdown(func() { close(departed) })
+
+	// 关闭前发出的请求需要完成
+	result := make(chan string, 1)
+	go func() {
+		res, err := http.Get(base + "/_groupcache/http-shutdown/Tom")
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		result <- string(body)
+	}()
+	<-loading
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	<-departed
+
+	// 等待期间依旧可以访问，但已不再就绪
+	res, err := http.Get(base + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("readyz returned %d while draining", res.StatusCode)
+	}
+
+	close(release)
+	if err := <-shutdown; err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if body := <-result; len(body) == 0 {
+		t.Fatalf("in-flight request got empty response")
+	}
+	if err := <-s.Done(); err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	if _, err := http.Get(base + "/healthz"); err == nil {
+		t.Fatalf("server should not accept requests after shutdown")
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	groupcache.NewGroup("http-shutdown-timeout", 2<<10, groupcache.GetterFunc(
+		func(key string) ([]byte, error) {
+			<-release
+			return []byte("630"), nil
+		}))
+
+	pool := NewPool("http://127.0.0.1:0")
+	s := NewServer(pool, "127.0.0.1:0")
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go http.Get("http://" + s.Addr().String() + "/_groupcache/http-shutdown-timeout/Tom")
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("shutdown should time out, got %v", err)
+	}
+}
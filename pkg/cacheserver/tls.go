@@ -0,0 +1,194 @@
+package cacheserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// 证书文件变化的默认检查间隔
+const defaultTLSReloadInterval = time.Minute
+
+// TLSConfig configures TLS of peer traffic.
+// CertFile 与 KeyFile 为本节点的证书，同时用作服务端证书与 mTLS 的客户端证书；
+// CAFile 用于验证对端证书，ClientAuth 为 true 时要求对端提供由 CAFile 签发的客户端证书
+type TLSConfig struct {
+	CertFile   string
+	KeyFile    string
+	CAFile     string
+	ClientAuth bool
+	// 每隔 ReloadInterval 检查一次证书文件，变化时重新加载，默认为 defaultTLSReloadInterval
+	ReloadInterval time.Duration
+}
+
+// TLS holds the certificates of a node and reloads them when the files change,
+// so rotated certificates are used by new connections without a restart.
+// 检查在握手时进行，两次检查至少间隔 ReloadInterval；加载失败时继续使用原有证书
+type TLS struct {
+	cfg TLSConfig
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	roots     *x509.CertPool
+	modTimes  []time.Time
+	lastCheck time.Time
+	// now 用于获取当前时间，测试时可替换
+	now func() time.Time
+}
+
+// NewTLS loads the certificates of cfg.
+func NewTLS(cfg TLSConfig) (*TLS, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("tls: CertFile and KeyFile are required")
+	}
+	if cfg.ClientAuth && cfg.CAFile == "" {
+		return nil, errors.New("tls: CAFile is required for client authentication")
+	}
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = defaultTLSReloadInterval
+	}
+	t := &TLS{cfg: cfg, now: time.Now}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	t.lastCheck = t.now()
+	return t, nil
+}
+
+// ServerConfig returns the tls.Config for serving peers.
+func (t *TLS) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// 每次握手时生成配置，使重新加载的证书与 CA 立即生效
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, roots := t.current()
+			c := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if t.cfg.ClientAuth {
+				c.ClientAuth = tls.RequireAndVerifyClientCert
+				c.ClientCAs = roots
+			}
+			return c, nil
+		},
+	}
+}
+
+// ClientConfig returns the tls.Config for requests to peers.
+func (t *TLS) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := t.current()
+			return cert, nil
+		},
+		// RootCAs 无法在建立连接时更新，因此关闭默认的验证，改为在 VerifyConnection 中
+		// 使用当前的 CA 验证，效果与默认验证相同
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			_, roots := t.current()
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("tls: peer sent no certificate")
+			}
+			opts := x509.VerifyOptions{
+				Roots:         roots,
+				DNSName:       cs.ServerName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		},
+	}
+}
+
+// Reload reloads the certificates if the files have changed.
+func (t *TLS) Reload() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastCheck = t.now()
+	return t.reloadLocked()
+}
+
+// current 返回当前的证书与 CA，距上次检查超过 ReloadInterval 时先检查文件是否变化
+func (t *TLS) current() (*tls.Certificate, *x509.CertPool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now := t.now(); now.Sub(t.lastCheck) >= t.cfg.ReloadInterval {
+		t.lastCheck = now
+		if err := t.reloadLocked(); err != nil {
+			log.Println("[TLS] Failed to reload certificates:", err)
+		}
+	}
+	return t.cert, t.roots
+}
+
+func (t *TLS) reloadLocked() error {
+	modTimes, err := t.stat()
+	if err != nil {
+		return err
+	}
+	for i := range modTimes {
+		if !modTimes[i].Equal(t.modTimes[i]) {
+			log.Println("[TLS] Reloading certificates")
+			return t.loadLocked()
+		}
+	}
+	return nil
+}
+
+func (t *TLS) load() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.loadLocked()
+}
+
+// loadLocked 读取证书文件，全部成功后才替换当前的证书
+func (t *TLS) loadLocked() error {
+	modTimes, err := t.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(t.cfg.CertFile, t.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	var roots *x509.CertPool
+	if t.cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.cfg.CAFile)
+		if err != nil {
+			return err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls: no certificates found in %s", t.cfg.CAFile)
+		}
+	}
+	t.cert, t.roots, t.modTimes = &cert, roots, modTimes
+	return nil
+}
+
+func (t *TLS) stat() ([]time.Time, error) {
+	files := []string{t.cfg.CertFile, t.cfg.KeyFile}
+	if t.cfg.CAFile != "" {
+		files = append(files, t.cfg.CAFile)
+	}
+	modTimes := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
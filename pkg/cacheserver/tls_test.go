@@ -0,0 +1,244 @@
+package cacheserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fusidic/FuCache/pkg/groupcache"
+	"github.com/fusidic/FuCache/proto/cachepb"
+)
+
+// testCA 用于在测试中签发证书
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fucache test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue 签发用于 127.0.0.1 的证书，同时可用作服务端与客户端证书，返回证书与私钥的 PEM
+func (ca *testCA) issue(t *testing.T, serial int64) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "fucache node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeCerts 写入证书文件，并将修改时间设置为 modTime
+func writeCerts(t *testing.T, dir string, files map[string][]byte, modTime time.Time) TLSConfig {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return TLSConfig{
+		CertFile:   filepath.Join(dir, "node.crt"),
+		KeyFile:    filepath.Join(dir, "node.key"),
+		CAFile:     filepath.Join(dir, "ca.crt"),
+		ClientAuth: true,
+	}
+}
+
+func tempCertDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "fucache-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestPoolMutualTLS(t *testing.T) {
+	groupcache.NewGroup("http-tls", 2<<10, groupcache.GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+
+	ca := newTestCA(t)
+	crt, key := ca.issue(t, 2)
+	cfg := writeCerts(t, tempCertDir(t), map[string][]byte{"node.crt": crt, "node.key": key, "ca.crt": ca.pem}, time.Now())
+	nodeTLS, err := NewTLS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer(NewPool("https://127.0.0.1:0", WithTLS(nodeTLS)), "127.0.0.1:0")
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Shutdown(context.Background())
+	addr := "https://" + server.Addr().String()
+
+	client := NewPool("https://127.0.0.1:1", WithTLS(nodeTLS), WithFallbackPeers(0))
+	client.Set(addr)
+	peer, _ := client.PickPeer("Tom")
+	res := &cachepb.Response{}
+	if err := peer.Get(context.Background(), &cachepb.Request{Group: "http-tls", Key: "Tom"}, res); err != nil {
+		t.Fatalf("get over mTLS: %v", err)
+	}
+	if string(res.Value) != "630" {
+		t.Fatalf("got %q", res.Value)
+	}
+
+	// 不提供客户端证书的请求被拒绝
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	noCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	if _, err := noCert.Get(addr + "/_groupcache/http-tls/Tom"); err == nil {
+		t.Fatalf("request without client certificate should be rejected")
+	}
+	// 明文请求被拒绝
+	if res, err := http.Get("http://" + server.Addr().String() + "/_groupcache/http-tls/Tom"); err == nil && res.StatusCode == http.StatusOK {
+		t.Fatalf("plain http request should be rejected")
+	}
+
+	// 其他 CA 签发的服务端证书不被信任
+	other := newTestCA(t)
+	otherCrt, otherKey := other.issue(t, 3)
+	otherCfg := writeCerts(t, tempCertDir(t), map[string][]byte{"node.crt": otherCrt, "node.key": otherKey, "ca.crt": other.pem}, time.Now())
+	otherTLS, err := NewTLS(otherCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	untrusted := NewPool("https://127.0.0.1:1", WithTLS(otherTLS), WithFallbackPeers(0), WithBreaker(BreakerConfig{}))
+	untrusted.Set(addr)
+	peer, _ = untrusted.PickPeer("Tom")
+	if err := peer.Get(context.Background(), &cachepb.Request{Group: "http-tls", Key: "Tom"}, &cachepb.Response{}); err == nil {
+		t.Fatalf("server certificate from another CA should not be trusted")
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	ca := newTestCA(t)
+	dir := tempCertDir(t)
+	start := time.Now().Add(-time.Hour)
+	crt, key := ca.issue(t, 2)
+	cfg := writeCerts(t, dir, map[string][]byte{"node.crt": crt, "node.key": key, "ca.crt": ca.pem}, start)
+	cfg.ReloadInterval = time.Minute
+	nodeTLS, err := NewTLS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 服务端在其他 goroutine 中握手，now 需要加锁
+	var mu sync.Mutex
+	now := time.Now()
+	nodeTLS.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", nodeTLS.ServerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	serial := func() int64 {
+		t.Helper()
+		conn, err := tls.Dial("tcp", lis.Addr().String(), nodeTLS.ClientConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	if s := serial(); s != 2 {
+		t.Fatalf("serial %d, expect 2", s)
+	}
+	// 轮换证书，ReloadInterval 内依旧使用原有证书
+	crt, key = ca.issue(t, 3)
+	writeCerts(t, dir, map[string][]byte{"node.crt": crt, "node.key": key}, start.Add(time.Second))
+	if s := serial(); s != 2 {
+		t.Fatalf("serial %d before reload interval, expect 2", s)
+	}
+	mu.Lock()
+	now = now.Add(time.Minute)
+	mu.Unlock()
+	if s := serial(); s != 3 {
+		t.Fatalf("serial %d after reload, expect 3", s)
+	}
+
+	// 损坏的证书不会替换当前证书
+	writeCerts(t, dir, map[string][]byte{"node.crt": []byte("broken")}, start.Add(2*time.Second))
+	if err := nodeTLS.Reload(); err == nil {
+		t.Fatalf("reloading broken certificate should fail")
+	}
+	if s := serial(); s != 3 {
+		t.Fatalf("serial %d after broken reload, expect 3", s)
+	}
+}
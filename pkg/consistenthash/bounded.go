@@ -0,0 +1,93 @@
+package consistenthash
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// defaultLoadWindow 为负载计数衰减的周期，每经过一个周期计数减半
+const defaultLoadWindow = time.Second
+
+// Loads tracks recent requests of nodes for consistent hashing with bounded loads,
+// it is safe for concurrent access.
+// 节点的负载上限为 factor * 平均负载（按权重分配），超出上限的节点会被跳过，
+// key 溢出到哈希环上的下一个节点
+type Loads struct {
+	mu     sync.Mutex
+	factor float64
+	window time.Duration
+	loads  map[string]float64
+	total  float64
+	last   time.Time
+	// now 用于获取当前时间，测试时可替换
+	now func() time.Time
+}
+
+// NewLoads creates a Loads with the given factor, which should be greater than 1.
+// 计数每秒减半，因此反映的是最近的请求量
+func NewLoads(factor float64) *Loads {
+	if factor < 1 {
+		factor = 1
+	}
+	return &Loads{
+		factor: factor,
+		window: defaultLoadWindow,
+		loads:  make(map[string]float64),
+		now:    time.Now,
+	}
+}
+
+// Load returns the recent load of node.
+func (l *Loads) Load(node string) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.decay()
+	return l.loads[node]
+}
+
+// decay 按经过的周期数衰减计数，调用方需要持有 mu
+func (l *Loads) decay() {
+	now := l.now()
+	if l.last.IsZero() {
+		l.last = now
+		return
+	}
+	periods := int(now.Sub(l.last) / l.window)
+	if periods <= 0 {
+		return
+	}
+	l.last = l.last.Add(time.Duration(periods) * l.window)
+	scale := math.Pow(0.5, float64(periods))
+	l.total *= scale
+	for node, load := range l.loads {
+		if load *= scale; load < 0.5 {
+			delete(l.loads, node)
+		} else {
+			l.loads[node] = load
+		}
+	}
+}
+
+// GetBounded returns the node for key with bounded loads and records the request.
+// 从 key 在环上的位置开始，选择第一个负载未超过上限的节点
+func (m *Map) GetBounded(key string, l *Loads) string {
+	var totalWeight int
+	for _, weight := range m.weights {
+		totalWeight += weight
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.decay()
+	node := m.GetFunc(key, func(node string) bool {
+		share := float64(m.weights[node]) / float64(totalWeight)
+		limit := math.Ceil(l.factor * (l.total + 1) * share)
+		return l.loads[node]+1 <= limit
+	})
+	if node != "" {
+		l.loads[node]++
+		l.total++
+	}
+	return node
+}
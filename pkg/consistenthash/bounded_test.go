@@ -0,0 +1,54 @@
+package consistenthash
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGetFunc(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		var i uint32
+		for _, b := range key {
+			i = i*10 + uint32(b-'0')
+		}
+		return i
+	})
+	hash.Add("6", "4", "2")
+
+	// 23 最近的虚拟节点为 24("4")，其后为 26("6")
+	if node := hash.GetFunc("23", func(node string) bool { return node != "4" }); node != "6" {
+		t.Fatalf("GetFunc should skip 4 and yield 6, got %s", node)
+	}
+	if node := hash.GetFunc("23", func(string) bool { return false }); node != "4" {
+		t.Fatalf("GetFunc should fall back to the closest node, got %s", node)
+	}
+}
+
+func TestGetBounded(t *testing.T) {
+	hash := New(50, nil)
+	hash.Add("a", "b", "c")
+	now := time.Now()
+	loads := NewLoads(1.25)
+	loads.now = func() time.Time { return now }
+
+	// 同一个热点 key 被频繁访问，超过上限后应溢出到其他节点
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		counts[hash.GetBounded("hot", loads)]++
+	}
+	primary := hash.Get("hot")
+	limit := int(math.Ceil(1.25 * 300 / 3))
+	if counts[primary] > limit {
+		t.Fatalf("primary %s got %d requests, exceed limit %d", primary, counts[primary], limit)
+	}
+	if len(counts) < 2 {
+		t.Fatalf("hot key should spill to other nodes, got %v", counts)
+	}
+
+	// 经过两个周期后负载衰减为 1/4
+	now = now.Add(2 * defaultLoadWindow)
+	if load := loads.Load(primary); load != float64(counts[primary])/4 {
+		t.Fatalf("load of %s is %v after decay, expect %v", primary, load, float64(counts[primary])/4)
+	}
+}
@@ -15,6 +15,10 @@ type Map struct {
 	replicas int            // 虚拟节点倍数
 	nodes    []int          // Sorted 哈希环，放置虚拟节点的哈希值
 	hashMap  map[int]string // k:虚拟节点的哈希值 v:真实节点的名称
+	weights  map[string]int // k:真实节点的名称 v:权重，虚拟节点数为 replicas*weight
+	// 哈希冲突时多个真实节点拥有同一个虚拟节点，k:虚拟节点的哈希值 v:按名称排序的真实节点；
+	// 名称最小的节点负责该虚拟节点，与加入顺序无关，移除后由下一个节点接替
+	owners map[int][]string
 }
 
 // New creates a Map instance
@@ -24,6 +28,8 @@ func New(replicas int, fn Hash) *Map {
 		replicas: replicas,
 		hash:     fn,
 		hashMap:  make(map[int]string),
+		weights:  make(map[string]int),
+		owners:   make(map[int][]string),
 	}
 	if m.hash == nil {
 		m.hash = crc32.ChecksumIEEE
@@ -39,14 +45,111 @@ func New(replicas int, fn Hash) *Map {
 // 最后会将环 keys 上的哈希值进行排序 (从小到大)
 func (m *Map) Add(nodes ...string) {
 	for _, node := range nodes {
-		for i := 0; i < m.replicas; i++ {
-			// 增加编号，转换为字节码并取哈希
-			hash := int(m.hash([]byte(strconv.Itoa(i) + node)))
+		m.addNode(node, 1)
+	}
+	sort.Ints(m.nodes)
+}
+
+// AddWeighted adds a node with weight to the hash ring.
+// 节点拥有 m.replicas * weight 个虚拟节点，分到的 key 与权重大致成正比
+func (m *Map) AddWeighted(node string, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	m.addNode(node, weight)
+	sort.Ints(m.nodes)
+}
+
+// addNode 添加节点的虚拟节点，调用方需要对 m.nodes 排序
+func (m *Map) addNode(node string, weight int) {
+	m.weights[node] = weight
+	for i := 0; i < m.replicas*weight; i++ {
+		// 增加编号，转换为字节码并取哈希
+		hash := int(m.hash([]byte(strconv.Itoa(i) + node)))
+		owners := m.owners[hash]
+		if len(owners) == 0 {
 			m.nodes = append(m.nodes, hash)
-			m.hashMap[hash] = node
 		}
+		// 创建新的切片，Clone 出的 Map 与原 Map 共享旧切片
+		idx := sort.SearchStrings(owners, node)
+		owners = append(append(append([]string(nil), owners[:idx]...), node), owners[idx:]...)
+		m.owners[hash] = owners
+		m.hashMap[hash] = owners[0]
 	}
-	sort.Ints(m.nodes)
+}
+
+// Weight returns the weight of the node, 0 if the node is not in the ring.
+func (m *Map) Weight(node string) int {
+	return m.weights[node]
+}
+
+// Remove removes some nodes and all their virtual nodes from the hash ring.
+// 只有被移除节点的虚拟节点所负责的 key 会迁移到环上的下一个节点，其余 key 不受影响
+func (m *Map) Remove(nodes ...string) {
+	removed := make(map[int]bool)
+	for _, node := range nodes {
+		weight, ok := m.weights[node]
+		if !ok {
+			continue
+		}
+		delete(m.weights, node)
+		for i := 0; i < m.replicas*weight; i++ {
+			hash := int(m.hash([]byte(strconv.Itoa(i) + node)))
+			// 哈希冲突时虚拟节点还属于其他真实节点，只移除 node 的所有权
+			var left []string
+			for _, owner := range m.owners[hash] {
+				if owner != node {
+					left = append(left, owner)
+				}
+			}
+			if len(left) > 0 {
+				m.owners[hash] = left
+				m.hashMap[hash] = left[0]
+				continue
+			}
+			if _, ok := m.owners[hash]; ok {
+				removed[hash] = true
+				delete(m.owners, hash)
+				delete(m.hashMap, hash)
+			}
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+	// m.nodes 已经有序，过滤后依旧有序
+	nodesLeft := m.nodes[:0]
+	for _, hash := range m.nodes {
+		if !removed[hash] {
+			nodesLeft = append(nodesLeft, hash)
+		}
+	}
+	m.nodes = nodesLeft
+}
+
+// Clone returns a copy of the Map, so that it can be modified
+// while the original is still in use.
+func (m *Map) Clone() Placement {
+	c := &Map{
+		hash:     m.hash,
+		replicas: m.replicas,
+		nodes:    make([]int, len(m.nodes)),
+		hashMap:  make(map[int]string, len(m.hashMap)),
+		weights:  make(map[string]int, len(m.weights)),
+		owners:   make(map[int][]string, len(m.owners)),
+	}
+	copy(c.nodes, m.nodes)
+	for k, v := range m.hashMap {
+		c.hashMap[k] = v
+	}
+	// owners 中的切片只会被整体替换，可以共享
+	for k, v := range m.owners {
+		c.owners[k] = v
+	}
+	for k, v := range m.weights {
+		c.weights[k] = v
+	}
+	return c
 }
 
 // Get the closest Node in the hash-ring provided key.
@@ -55,15 +158,58 @@ func (m *Map) Get(key string) string {
 		return ""
 	}
 
+	// 获取真实节点的名称
+	// 取余是为了处理 idx == len(m.keys) 的情况，即keys数组的最后一位
+	return m.hashMap[m.nodes[m.search(key)%len(m.nodes)]]
+}
+
+// GetFunc returns the first node clockwise from key for which accept returns true,
+// the closest node is returned if no node is accepted.
+// 沿哈希环顺时针查找，每个真实节点只检查一次
+func (m *Map) GetFunc(key string, accept func(node string) bool) string {
+	if len(m.nodes) == 0 {
+		return ""
+	}
+	idx := m.search(key)
+	seen := make(map[string]bool, len(m.weights))
+	for i := 0; i < len(m.nodes) && len(seen) < len(m.weights); i++ {
+		node := m.hashMap[m.nodes[(idx+i)%len(m.nodes)]]
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+		if accept(node) {
+			return node
+		}
+	}
+	return m.hashMap[m.nodes[idx%len(m.nodes)]]
+}
+
+// GetN returns at most n distinct nodes clockwise from key,
+// the first one is the closest node, the others are its successors.
+func (m *Map) GetN(key string, n int) []string {
+	if n > len(m.weights) {
+		n = len(m.weights)
+	}
+	nodes := make([]string, 0, n)
+	if n <= 0 {
+		return nodes
+	}
+	m.GetFunc(key, func(node string) bool {
+		nodes = append(nodes, node)
+		return len(nodes) == n
+	})
+	return nodes
+}
+
+// search returns the index of the first virtual node clockwise from key,
+// len(m.nodes) means it wraps to the first virtual node.
+func (m *Map) search(key string) int {
 	// 根据 key 计算哈希值
 	hash := int(m.hash([]byte(key)))
 	// sort.Search 返回第一个为 true 的值，否则返回 n，这里用于寻找虚拟节点
-	idx := sort.Search(len(m.nodes), func(i int) bool {
+	return sort.Search(len(m.nodes), func(i int) bool {
 		// 首个大于 hash 的哈希值，即虚拟节点
 		return m.nodes[i] >= hash
 	})
-
-	// 获取真实节点的名称
-	// 取余是为了处理 idx == len(m.keys) 的情况，即keys数组的最后一位
-	return m.hashMap[m.nodes[idx%len(m.nodes)]]
 }
@@ -1,6 +1,10 @@
 package consistenthash
 
 import (
+	"crypto/md5"
+	"encoding/binary"
+	"math"
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -36,3 +40,140 @@ func Test_Hashing(t *testing.T) {
 		}
 	}
 }
+
+func TestRemove(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+
+	hash.Add("6", "4", "2")
+	hash.Remove("4")
+
+	// 4、14、24 被移除后，原本属于 "4" 的 key 迁移到下一个节点
+	testCases := map[string]string{
+		"2":  "2",
+		"11": "2",
+		"23": "6",
+		"27": "2",
+	}
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s, should have yielded %s", k, v)
+		}
+	}
+
+	hash.Remove("2", "6")
+	if hash.Get("2") != "" {
+		t.Errorf("empty ring should yield nothing")
+	}
+}
+
+func TestRemoveCollision(t *testing.T) {
+	// 按最后一个字符取哈希，"a" 与 "ba" 的虚拟节点全部冲突
+	lastByte := func(key []byte) uint32 {
+		return uint32(key[len(key)-1])
+	}
+	for _, order := range [][]string{{"a", "ba"}, {"ba", "a"}} {
+		hash := New(2, lastByte)
+		hash.Add(order...)
+		if got := hash.Get("a"); got != "a" {
+			t.Fatalf("added in order %v, owner is %s, expect a", order, got)
+		}
+	}
+
+	hash := New(2, lastByte)
+	hash.Add("a", "ba", "c")
+	clone := hash.Clone()
+	// 移除 "ba" 不影响与之冲突的 "a"
+	hash.Remove("ba")
+	if got := hash.Get("a"); got != "a" {
+		t.Fatalf("removing ba should keep a, got %s", got)
+	}
+	// 移除 "a" 后由 "ba" 接替冲突的虚拟节点
+	clone.Remove("a")
+	if got := clone.Get("a"); got != "ba" {
+		t.Fatalf("removing a should hand over to ba, got %s", got)
+	}
+	if got := hash.Get("a"); got != "a" {
+		t.Fatalf("clone should not affect the original, got %s", got)
+	}
+	hash.Remove("a")
+	if got := hash.Get("a"); got != "c" {
+		t.Fatalf("all owners removed, expect c, got %s", got)
+	}
+}
+
+func TestClone(t *testing.T) {
+	hash := New(50, nil)
+	hash.Add("a", "b", "c")
+	clone := hash.Clone()
+	clone.Remove("a")
+
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		before, after := hash.Get(key), clone.Get(key)
+		if before != after {
+			if before != "a" {
+				t.Fatalf("key %s moved from %s to %s", key, before, after)
+			}
+			moved++
+		}
+		if after == "a" {
+			t.Fatalf("key %s still maps to removed node", key)
+		}
+	}
+	if moved == 0 {
+		t.Fatalf("no key moved away from removed node")
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	// crc32 对相似的节点名分布很不均匀，这里使用 md5 以便验证权重
+	hash := New(200, func(key []byte) uint32 {
+		sum := md5.Sum(key)
+		return binary.BigEndian.Uint32(sum[:4])
+	})
+	weights := map[string]int{
+		"http://localhost:8001": 1,
+		"http://localhost:8002": 2,
+		"http://localhost:8003": 4,
+	}
+	for node, weight := range weights {
+		hash.AddWeighted(node, weight)
+	}
+
+	const keys = 70000
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		counts[hash.Get("key"+strconv.Itoa(i))]++
+	}
+	for node, weight := range weights {
+		expect := float64(keys) * float64(weight) / 7
+		if share := float64(counts[node]); math.Abs(share-expect)/expect > 0.15 {
+			t.Errorf("node %s with weight %d got %d keys, expect about %.0f", node, weight, counts[node], expect)
+		}
+	}
+
+	hash.Remove("http://localhost:8003")
+	if len(hash.nodes) != 200*3 || hash.Weight("http://localhost:8003") != 0 {
+		t.Fatalf("virtual nodes of weighted node are not removed")
+	}
+}
+
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	hash.Add("6", "4", "2")
+
+	// 23 -> 24("4") -> 26("6") -> 32("2")
+	if nodes := hash.GetN("23", 3); !reflect.DeepEqual(nodes, []string{"4", "6", "2"}) {
+		t.Errorf("GetN(23, 3) = %v", nodes)
+	}
+	if nodes := hash.GetN("23", 0); len(nodes) != 0 {
+		t.Errorf("GetN(23, 0) = %v", nodes)
+	}
+}
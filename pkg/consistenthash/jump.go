@@ -0,0 +1,134 @@
+package consistenthash
+
+import "sort"
+
+// Jump implements jump consistent hash by Lamping and Veach.
+// 每个节点按权重占据若干个桶，Get 的复杂度为 O(ln 桶数) 且不需要额外内存；
+// 桶按节点名称排序，各节点以任意顺序加入节点都能得到相同的结果；
+// 但只有加入或移除名称最大的节点时迁移量最小，其他节点的变化会使其后的桶整体移动，
+// 几乎所有 key 都会迁移，因此不适用于节点随节点发现变化的场景
+type Jump struct {
+	hash    Hash64
+	buckets []string       // 按节点名称排序，节点按权重重复出现
+	weights map[string]int // k:节点名称 v:权重
+}
+
+// NewJump creates a Jump instance.
+// Hash will be set as FNV-1a mixed by splitmix64 by default.
+func NewJump(fn Hash64) *Jump {
+	j := &Jump{
+		hash:    fn,
+		weights: make(map[string]int),
+	}
+	if j.hash == nil {
+		j.hash = defaultHash64
+	}
+	return j
+}
+
+// Add adds some nodes with weight 1.
+func (j *Jump) Add(nodes ...string) {
+	for _, node := range nodes {
+		j.AddWeighted(node, 1)
+	}
+}
+
+// AddWeighted adds a node with weight, the weight of an existing node is replaced.
+func (j *Jump) AddWeighted(node string, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	j.Remove(node)
+	j.weights[node] = weight
+	idx := sort.SearchStrings(j.buckets, node)
+	buckets := make([]string, 0, len(j.buckets)+weight)
+	buckets = append(buckets, j.buckets[:idx]...)
+	for i := 0; i < weight; i++ {
+		buckets = append(buckets, node)
+	}
+	j.buckets = append(buckets, j.buckets[idx:]...)
+}
+
+// Remove removes some nodes.
+func (j *Jump) Remove(nodes ...string) {
+	removed := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		if _, ok := j.weights[node]; ok {
+			removed[node] = true
+			delete(j.weights, node)
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+	left := j.buckets[:0]
+	for _, node := range j.buckets {
+		if !removed[node] {
+			left = append(left, node)
+		}
+	}
+	j.buckets = left
+}
+
+// Get the node of the bucket which key jumps to.
+func (j *Jump) Get(key string) string {
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	return j.buckets[jumpHash(j.hash([]byte(key)), len(j.buckets))]
+}
+
+// GetN returns at most n distinct nodes for key.
+// 依次将已选中的节点从桶中排除后再次计算，即节点被移除后 key 会跳转到的节点
+func (j *Jump) GetN(key string, n int) []string {
+	if n > len(j.weights) {
+		n = len(j.weights)
+	}
+	nodes := make([]string, 0, n)
+	if n <= 0 {
+		return nodes
+	}
+	hash := j.hash([]byte(key))
+	buckets := j.buckets
+	for len(nodes) < n {
+		node := buckets[jumpHash(hash, len(buckets))]
+		nodes = append(nodes, node)
+		left := make([]string, 0, len(buckets))
+		for _, b := range buckets {
+			if b != node {
+				left = append(left, b)
+			}
+		}
+		buckets = left
+	}
+	return nodes
+}
+
+// Weight returns the weight of the node.
+func (j *Jump) Weight(node string) int {
+	return j.weights[node]
+}
+
+// Clone returns a copy of the Jump.
+func (j *Jump) Clone() Placement {
+	c := &Jump{
+		hash:    j.hash,
+		buckets: append([]string(nil), j.buckets...),
+		weights: make(map[string]int, len(j.weights)),
+	}
+	for k, v := range j.weights {
+		c.weights[k] = v
+	}
+	return c
+}
+
+// jumpHash maps key to a bucket in [0, n).
+func jumpHash(key uint64, n int) int {
+	var b, i int64 = -1, 0
+	for i < int64(n) {
+		b = i
+		key = key*2862933555777941757 + 1
+		i = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,59 @@
+package consistenthash
+
+import "hash/fnv"
+
+// Placement decides which node owns a key.
+// Map（哈希环）、Rendezvous（最高随机权重）与 Jump（跳跃一致性哈希）均实现了该接口，
+// 它们都不是并发安全的，修改时应先 Clone 再替换；
+// Jump 只有在名称最大的节点加入或移除时迁移量最小，只适用于节点固定或按名称顺序扩缩容的场景
+type Placement interface {
+	// Add adds nodes with weight 1.
+	Add(nodes ...string)
+	// AddWeighted adds a node with weight, a larger weight owns more keys.
+	AddWeighted(node string, weight int)
+	// Remove removes nodes.
+	Remove(nodes ...string)
+	// Get returns the node owning key, "" if there's no node.
+	Get(key string) string
+	// GetN returns at most n distinct nodes for key in order of preference,
+	// the first one is the same as Get.
+	GetN(key string, n int) []string
+	// Weight returns the weight of the node, 0 if the node doesn't exist.
+	Weight(node string) int
+	// Clone returns a copy which can be modified independently.
+	Clone() Placement
+}
+
+// BoundedPlacement is a Placement supporting consistent hashing with bounded loads,
+// only Map implements it since spilling requires the order of the ring.
+type BoundedPlacement interface {
+	Placement
+	GetBounded(key string, l *Loads) string
+}
+
+var (
+	_ BoundedPlacement = (*Map)(nil)
+	_ Placement        = (*Map)(nil)
+	_ Placement        = (*Rendezvous)(nil)
+	_ Placement        = (*Jump)(nil)
+)
+
+// Hash64 maps bytes to uint64
+type Hash64 func(data []byte) uint64
+
+// defaultHash64 为 FNV-1a 加上 splitmix64 的混淆，使相似输入的输出也足够分散
+func defaultHash64(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return mix64(h.Sum64())
+}
+
+// mix64 is the finalizer of splitmix64.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,241 @@
+package consistenthash
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+var placements = []struct {
+	name string
+	new  func() Placement
+}{
+	{"ring", func() Placement { return New(50, nil) }},
+	{"rendezvous", func() Placement { return NewRendezvous(nil) }},
+	{"jump", func() Placement { return NewJump(nil) }},
+}
+
+func peerNames(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("http://localhost:%d", 8001+i)
+	}
+	return nodes
+}
+
+// distribution 返回各节点分到 key 数量的变异系数（标准差 / 平均值）
+func distribution(p Placement, nodes []string, keys int) float64 {
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		counts[p.Get("key"+strconv.Itoa(i))]++
+	}
+	mean := float64(keys) / float64(len(nodes))
+	var variance float64
+	for _, node := range nodes {
+		d := float64(counts[node]) - mean
+		variance += d * d
+	}
+	return math.Sqrt(variance/float64(len(nodes))) / mean
+}
+
+// movement 返回节点 node 加入或离开后迁移的 key 占比，以及其中不涉及 node 的占比；
+// 理想情况下只有 node 的 key（约 1/节点数）迁移，后者为 0
+func movement(p Placement, change func(Placement), node string, keys int) (moved, excess float64) {
+	c := p.Clone()
+	change(c)
+	var n, e int
+	for i := 0; i < keys; i++ {
+		key := "key" + strconv.Itoa(i)
+		before, after := p.Get(key), c.Get(key)
+		if before == after {
+			continue
+		}
+		n++
+		if before != node && after != node {
+			e++
+		}
+	}
+	return float64(n) / float64(keys), float64(e) / float64(keys)
+}
+
+func TestPlacementDistribution(t *testing.T) {
+	const keys = 100000
+	nodes := peerNames(10)
+	for _, pc := range placements {
+		p := pc.new()
+		p.Add(nodes...)
+		cv := distribution(p, nodes, keys)
+		t.Logf("%-10s coefficient of variation %.3f", pc.name, cv)
+		if pc.name != "ring" && cv > 0.05 {
+			t.Errorf("%s is not balanced: %.3f", pc.name, cv)
+		}
+	}
+}
+
+func TestPlacementMovement(t *testing.T) {
+	const keys = 100000
+	nodes := peerNames(10)
+	// 节点列表来自节点发现，任意节点都可能加入或离开
+	changes := []struct {
+		name string
+		node string
+		add  bool
+	}{
+		{"remove first", nodes[0], false},
+		{"remove middle", nodes[len(nodes)/2], false},
+		{"add before all", "http://localhost:8000", true},
+	}
+	for _, pc := range placements {
+		if pc.name == "jump" {
+			continue
+		}
+		p := pc.new()
+		p.Add(nodes...)
+		for _, c := range changes {
+			node, add := c.node, c.add
+			moved, excess := movement(p, func(p Placement) {
+				if add {
+					p.Add(node)
+				} else {
+					p.Remove(node)
+				}
+			}, node, keys)
+			t.Logf("%-10s %-14s moved %.3f", pc.name, c.name, moved)
+			if excess > 0 {
+				t.Errorf("%s moved %.3f keys not owned by the changed node after %s", pc.name, excess, c.name)
+			}
+		}
+	}
+}
+
+func TestJumpMovement(t *testing.T) {
+	const keys = 100000
+	nodes := peerNames(10)
+	p := NewJump(nil)
+	p.Add(nodes...)
+	// 只有名称最大的节点加入或离开时，迁移的都是该节点的 key
+	last, next := nodes[len(nodes)-1], "http://localhost:9000"
+	if _, excess := movement(p, func(p Placement) { p.Remove(last) }, last, keys); excess > 0 {
+		t.Errorf("jump moved %.3f other keys after removing the last node", excess)
+	}
+	if _, excess := movement(p, func(p Placement) { p.Add(next) }, next, keys); excess > 0 {
+		t.Errorf("jump moved %.3f other keys after adding a node after all", excess)
+	}
+	// 中间的节点离开时其后的桶整体前移，这是 Pool 不使用 Jump 的原因
+	if moved, _ := movement(p, func(p Placement) { p.Remove(nodes[0]) }, nodes[0], keys); moved < 0.5 {
+		t.Errorf("removing the first node is expected to move most keys, moved %.3f", moved)
+	}
+}
+
+func TestPlacementWeighted(t *testing.T) {
+	const keys = 70000
+	for _, pc := range placements[1:] {
+		p := pc.new()
+		weights := map[string]int{"a": 1, "b": 2, "c": 4}
+		for node, weight := range weights {
+			p.AddWeighted(node, weight)
+		}
+		counts := make(map[string]int)
+		for i := 0; i < keys; i++ {
+			counts[p.Get("key"+strconv.Itoa(i))]++
+		}
+		for node, weight := range weights {
+			expect := float64(keys) * float64(weight) / 7
+			if math.Abs(float64(counts[node])-expect)/expect > 0.05 {
+				t.Errorf("%s: node %s with weight %d got %d keys, expect about %.0f",
+					pc.name, node, weight, counts[node], expect)
+			}
+		}
+	}
+}
+
+func TestPlacementRemove(t *testing.T) {
+	for _, pc := range placements {
+		p := pc.new()
+		if p.Get("Tom") != "" {
+			t.Errorf("%s: empty placement should yield nothing", pc.name)
+		}
+		p.Add("a", "b")
+		p.Remove("a")
+		for i := 0; i < 100; i++ {
+			if owner := p.Get(strconv.Itoa(i)); owner != "b" {
+				t.Errorf("%s: key %d owned by %q after removing a", pc.name, i, owner)
+			}
+		}
+		if p.Weight("a") != 0 || p.Weight("b") != 1 {
+			t.Errorf("%s: unexpected weights", pc.name)
+		}
+	}
+}
+
+func BenchmarkPlacementGet(b *testing.B) {
+	for _, n := range []int{10, 100} {
+		nodes := peerNames(n)
+		for _, pc := range placements {
+			p := pc.new()
+			p.Add(nodes...)
+			b.Run(fmt.Sprintf("%s/%d", pc.name, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					p.Get("key" + strconv.Itoa(i))
+				}
+			})
+		}
+	}
+}
+
+func TestPlacementGetN(t *testing.T) {
+	nodes := peerNames(5)
+	for _, pc := range placements {
+		p := pc.new()
+		p.Add(nodes...)
+		for i := 0; i < 100; i++ {
+			key := strconv.Itoa(i)
+			got := p.GetN(key, 3)
+			if len(got) != 3 || got[0] != p.Get(key) {
+				t.Fatalf("%s: GetN(%s, 3) = %v, primary %s", pc.name, key, got, p.Get(key))
+			}
+			seen := make(map[string]bool)
+			for _, node := range got {
+				if seen[node] {
+					t.Fatalf("%s: GetN(%s, 3) = %v has duplicates", pc.name, key, got)
+				}
+				seen[node] = true
+			}
+
+			// 主节点被移除后，原本的第二个节点应成为新的主节点
+			c := p.Clone()
+			c.Remove(got[0])
+			if c.Get(key) != got[1] {
+				t.Fatalf("%s: key %s should move to %s, got %s", pc.name, key, got[1], c.Get(key))
+			}
+		}
+		if got := p.GetN("Tom", 10); len(got) != len(nodes) {
+			t.Fatalf("%s: GetN should be capped by the number of nodes, got %v", pc.name, got)
+		}
+	}
+}
+
+func TestPlacementOrderIndependent(t *testing.T) {
+	nodes := peerNames(5)
+	reversed := make([]string, len(nodes))
+	for i, node := range nodes {
+		reversed[len(nodes)-1-i] = node
+	}
+	for _, pc := range placements {
+		a, b := pc.new(), pc.new()
+		for i := range nodes {
+			a.AddWeighted(nodes[i], i+1)
+		}
+		for i := range reversed {
+			b.AddWeighted(reversed[i], len(nodes)-i)
+		}
+		// 以不同顺序加入节点，各节点对 key 的归属判断一致
+		for i := 0; i < 1000; i++ {
+			key := "key" + strconv.Itoa(i)
+			if a.Get(key) != b.Get(key) {
+				t.Fatalf("%s: owner of %s depends on the order of nodes", pc.name, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package consistenthash
+
+import (
+	"math"
+	"sort"
+)
+
+// Rendezvous implements highest random weight hashing.
+// 对每个 key，计算其与每个节点组合的分数，分数最高的节点拥有该 key；
+// 增删节点时只有属于该节点的 key 会迁移，但 Get 的复杂度为 O(节点数)
+type Rendezvous struct {
+	hash    Hash64
+	nodes   []string       // 按名称排序，保证结果与加入顺序无关
+	weights map[string]int // k:节点名称 v:权重
+}
+
+// NewRendezvous creates a Rendezvous instance.
+// Hash will be set as FNV-1a mixed by splitmix64 by default.
+func NewRendezvous(fn Hash64) *Rendezvous {
+	r := &Rendezvous{
+		hash:    fn,
+		weights: make(map[string]int),
+	}
+	if r.hash == nil {
+		r.hash = defaultHash64
+	}
+	return r
+}
+
+// Add adds some nodes with weight 1.
+func (r *Rendezvous) Add(nodes ...string) {
+	for _, node := range nodes {
+		r.AddWeighted(node, 1)
+	}
+}
+
+// AddWeighted adds a node with weight.
+func (r *Rendezvous) AddWeighted(node string, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	if _, ok := r.weights[node]; !ok {
+		r.nodes = append(r.nodes, node)
+		sort.Strings(r.nodes)
+	}
+	r.weights[node] = weight
+}
+
+// Remove removes some nodes.
+func (r *Rendezvous) Remove(nodes ...string) {
+	for _, node := range nodes {
+		if _, ok := r.weights[node]; !ok {
+			continue
+		}
+		delete(r.weights, node)
+		idx := sort.SearchStrings(r.nodes, node)
+		r.nodes = append(r.nodes[:idx], r.nodes[idx+1:]...)
+	}
+}
+
+// Get the node with the highest score for key.
+func (r *Rendezvous) Get(key string) string {
+	var (
+		best  string
+		score = math.Inf(-1)
+	)
+	for _, node := range r.nodes {
+		if s := r.score(node, key); s > score {
+			best, score = node, s
+		}
+	}
+	return best
+}
+
+// GetN returns at most n nodes with the highest scores for key.
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if n > len(r.nodes) {
+		n = len(r.nodes)
+	}
+	if n <= 0 {
+		return []string{}
+	}
+	nodes := append([]string(nil), r.nodes...)
+	scores := make(map[string]float64, len(nodes))
+	for _, node := range nodes {
+		scores[node] = r.score(node, key)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return scores[nodes[i]] > scores[nodes[j]]
+	})
+	return nodes[:n]
+}
+
+// score 带权重的分数为 -weight / ln(h)，其中 h 为 (0, 1) 上均匀分布的哈希值
+func (r *Rendezvous) score(node, key string) float64 {
+	h := r.hash([]byte(node + key))
+	// 取高 53 位映射到 (0, 1)
+	u := (float64(h>>11) + 0.5) / (1 << 53)
+	return -float64(r.weights[node]) / math.Log(u)
+}
+
+// Weight returns the weight of the node.
+func (r *Rendezvous) Weight(node string) int {
+	return r.weights[node]
+}
+
+// Clone returns a copy of the Rendezvous.
+func (r *Rendezvous) Clone() Placement {
+	c := &Rendezvous{
+		hash:    r.hash,
+		nodes:   append([]string(nil), r.nodes...),
+		weights: make(map[string]int, len(r.weights)),
+	}
+	for k, v := range r.weights {
+		c.weights[k] = v
+	}
+	return c
+}
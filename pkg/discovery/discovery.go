@@ -0,0 +1,72 @@
+// Package discovery keeps the peer list of a cacheserver.Pool up to date.
+package discovery
+
+import (
+	"sort"
+	"time"
+
+	"github.com/fusidic/FuCache/pkg/cacheserver"
+)
+
+// Updater receives the full list of peers whenever membership changes,
+// it is implemented by cacheserver.Pool.
+type Updater interface {
+	SetPeers(peers ...cacheserver.Peer)
+}
+
+var _ Updater = (*cacheserver.Pool)(nil)
+
+// samePeers reports whether a and b contain the same peers with the same weights.
+// 忽略顺序，比较前会对副本排序
+func samePeers(a, b []cacheserver.Peer) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = sortedPeers(a), sortedPeers(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedPeers(peers []cacheserver.Peer) []cacheserver.Peer {
+	s := make([]cacheserver.Peer, len(peers))
+	copy(s, peers)
+	sort.Slice(s, func(i, j int) bool { return s[i].Addr < s[j].Addr })
+	return s
+}
+
+// poller 每隔 interval 调用一次 fn，直到 stop 被调用
+type poller struct {
+	quit chan struct{}
+	done chan struct{}
+}
+
+func (p *poller) start(interval time.Duration, fn func()) {
+	p.quit = make(chan struct{})
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.quit:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+}
+
+func (p *poller) stop() {
+	if p.quit == nil {
+		return
+	}
+	close(p.quit)
+	<-p.done
+	p.quit = nil
+}
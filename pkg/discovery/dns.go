@@ -0,0 +1,161 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusidic/FuCache/pkg/cacheserver"
+)
+
+const defaultResolveTimeout = 5 * time.Second
+
+// Resolver looks up DNS records, it is implemented by *net.Resolver.
+// 测试时可以替换为不访问网络的实现
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+var _ Resolver = net.DefaultResolver
+
+// DNSConfig configures DNS discovery.
+// SRV 为 true 时查询 SRV 记录，节点端口取自记录；Service 与 Proto 为空时直接查询 Name，
+// 否则查询 _Service._Proto.Name；SRV 为 false 时查询 A/AAAA 记录，节点端口为 Port
+type DNSConfig struct {
+	Name     string // 如 headless service 的名称 "fucache.default.svc.cluster.local"
+	SRV      bool
+	Service  string
+	Proto    string
+	Port     int
+	Scheme   string        // 节点 URL 的协议，默认为 "http"
+	Interval time.Duration // 解析间隔，默认为 defaultPollInterval
+	Timeout  time.Duration // 单次解析的超时时间，默认为 defaultResolveTimeout
+	Resolver Resolver      // 默认为 net.DefaultResolver
+}
+
+// DNS periodically resolves a DNS name into peer URLs and pushes them to an Updater.
+// 解析失败或结果为空时保留原有的节点列表，避免 DNS 短暂故障清空哈希环；
+// SRV 记录的优先级与权重被忽略，所有节点权重均为 1
+type DNS struct {
+	cfg     DNSConfig
+	updater Updater
+
+	mu    sync.Mutex
+	peers []cacheserver.Peer
+
+	poller poller
+}
+
+// NewDNS creates a DNS discovery, call Start to begin resolving.
+func NewDNS(cfg DNSConfig, updater Updater) *DNS {
+	if cfg.Scheme == "" {
+		cfg.Scheme = "http"
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultPollInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultResolveTimeout
+	}
+	if cfg.Resolver == nil {
+		cfg.Resolver = net.DefaultResolver
+	}
+	return &DNS{cfg: cfg, updater: updater}
+}
+
+// Resolve looks up the configured name and returns the peers sorted by address.
+func (d *DNS) Resolve(ctx context.Context) ([]cacheserver.Peer, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+
+	var hostports []string
+	if d.cfg.SRV {
+		_, records, err := d.cfg.Resolver.LookupSRV(ctx, d.cfg.Service, d.cfg.Proto, d.cfg.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range records {
+			host := strings.TrimSuffix(srv.Target, ".")
+			hostports = append(hostports, net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+		}
+	} else {
+		if d.cfg.Port <= 0 {
+			return nil, fmt.Errorf("resolve %s: port is required for A records", d.cfg.Name)
+		}
+		hosts, err := d.cfg.Resolver.LookupHost(ctx, d.cfg.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			hostports = append(hostports, net.JoinHostPort(host, strconv.Itoa(d.cfg.Port)))
+		}
+	}
+
+	// 同一地址可能出现在多条记录中
+	seen := make(map[string]bool, len(hostports))
+	peers := make([]cacheserver.Peer, 0, len(hostports))
+	for _, hp := range hostports {
+		addr := d.cfg.Scheme + "://" + hp
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		peers = append(peers, cacheserver.Peer{Addr: addr, Weight: 1})
+	}
+	return sortedPeers(peers), nil
+}
+
+// Start resolves once, then keeps resolving in background until Stop is called.
+// 首次解析失败时返回错误，不会开始轮询
+func (d *DNS) Start() error {
+	if err := d.refresh(true); err != nil {
+		return err
+	}
+	d.poller.start(d.cfg.Interval, func() {
+		if err := d.refresh(false); err != nil {
+			log.Printf("[Discovery] resolve %s: %v", d.cfg.Name, err)
+		}
+	})
+	return nil
+}
+
+// Stop stops resolving.
+func (d *DNS) Stop() {
+	d.poller.stop()
+}
+
+// Peers returns the peers last pushed to the Updater.
+func (d *DNS) Peers() []cacheserver.Peer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	peers := make([]cacheserver.Peer, len(d.peers))
+	copy(peers, d.peers)
+	return peers
+}
+
+// refresh 重新解析，并在节点列表变化 (或 force 为 true) 时通知 Updater
+func (d *DNS) refresh(force bool) error {
+	peers, err := d.Resolve(context.Background())
+	if err != nil {
+		return err
+	}
+	if len(peers) == 0 {
+		return fmt.Errorf("resolve %s: no records", d.cfg.Name)
+	}
+	d.mu.Lock()
+	if !force && samePeers(peers, d.peers) {
+		d.mu.Unlock()
+		return nil
+	}
+	d.peers = peers
+	d.mu.Unlock()
+	log.Printf("[Discovery] %d peers resolved from %s", len(peers), d.cfg.Name)
+	d.updater.SetPeers(peers...)
+	return nil
+}
@@ -0,0 +1,160 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fusidic/FuCache/pkg/cacheserver"
+)
+
+type fakeResolver struct {
+	mu    sync.Mutex
+	srv   []*net.SRV
+	hosts []string
+	err   error
+	// 最近一次 LookupSRV 查询的参数
+	query [3]string
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.query = [3]string{service, proto, name}
+	return name, r.srv, r.err
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hosts, r.err
+}
+
+func (r *fakeResolver) set(hosts []string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts, r.err = hosts, err
+}
+
+func TestDNSResolveSRV(t *testing.T) {
+	r := &fakeResolver{srv: []*net.SRV{
+		{Target: "b.fucache.local.", Port: 8002},
+		{Target: "a.fucache.local.", Port: 8001},
+		{Target: "a.fucache.local.", Port: 8001},
+	}}
+	d := NewDNS(DNSConfig{Name: "fucache.local", SRV: true, Service: "cache", Proto: "tcp", Resolver: r}, &fakeUpdater{})
+	peers, err := d.Resolve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []cacheserver.Peer{
+		{Addr: "http://a.fucache.local:8001", Weight: 1},
+		{Addr: "http://b.fucache.local:8002", Weight: 1},
+	}
+	if !reflect.DeepEqual(peers, want) {
+		t.Fatalf("got %v, want %v", peers, want)
+	}
+	if r.query != [3]string{"cache", "tcp", "fucache.local"} {
+		t.Errorf("queried %v", r.query)
+	}
+}
+
+func TestDNSResolveA(t *testing.T) {
+	r := &fakeResolver{hosts: []string{"10.0.0.2", "10.0.0.1", "fd00::1"}}
+	d := NewDNS(DNSConfig{Name: "fucache", Port: 8001, Scheme: "https", Resolver: r}, &fakeUpdater{})
+	peers, err := d.Resolve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []cacheserver.Peer{
+		{Addr: "https://10.0.0.1:8001", Weight: 1},
+		{Addr: "https://10.0.0.2:8001", Weight: 1},
+		{Addr: "https://[fd00::1]:8001", Weight: 1},
+	}
+	if !reflect.DeepEqual(peers, want) {
+		t.Fatalf("got %v, want %v", peers, want)
+	}
+
+	d = NewDNS(DNSConfig{Name: "fucache", Resolver: r}, &fakeUpdater{})
+	if _, err := d.Resolve(context.Background()); err == nil {
+		t.Error("expected error without port")
+	}
+}
+
+func TestDNSWatch(t *testing.T) {
+	r := &fakeResolver{hosts: []string{"10.0.0.1"}}
+	u := &fakeUpdater{}
+	d := NewDNS(DNSConfig{Name: "fucache", Port: 8001, Interval: 10 * time.Millisecond, Resolver: r}, u)
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	if peers := u.waitFor(t, 1); len(peers) != 1 {
+		t.Fatalf("initial peers %v", peers)
+	}
+
+	r.set([]string{"10.0.0.2", "10.0.0.1"}, nil)
+	if peers := u.waitFor(t, 2); len(peers) != 2 {
+		t.Fatalf("got %v", peers)
+	}
+
+	// 解析失败或结果为空时保留原有节点列表
+	r.set(nil, errors.New("no such host"))
+	time.Sleep(50 * time.Millisecond)
+	r.set(nil, nil)
+	time.Sleep(50 * time.Millisecond)
+	if calls, _ := u.last(); calls != 2 {
+		t.Fatalf("SetPeers called %d times, want 2", calls)
+	}
+	if peers := d.Peers(); len(peers) != 2 {
+		t.Fatalf("got %v", peers)
+	}
+
+	r.set([]string{"10.0.0.3"}, nil)
+	if peers := u.waitFor(t, 3); len(peers) != 1 || peers[0].Addr != "http://10.0.0.3:8001" {
+		t.Fatalf("got %v", peers)
+	}
+}
+
+func TestDNSStartError(t *testing.T) {
+	r := &fakeResolver{err: errors.New("no such host")}
+	d := NewDNS(DNSConfig{Name: "fucache", Port: 8001, Resolver: r}, &fakeUpdater{})
+	if err := d.Start(); err == nil {
+		t.Fatal("expected error")
+	}
+	d.Stop()
+}
+
+func TestDNSIncludesSelf(t *testing.T) {
+	// Pool 的 self 需要与 DNS 解析出的本节点地址一致，否则本节点拥有的 key 会被转发给自己
+	self := "http://10.0.0.1:8001"
+	pool := cacheserver.NewPool(self)
+	r := &fakeResolver{hosts: []string{"10.0.0.2", "10.0.0.1"}}
+	d := NewDNS(DNSConfig{Name: "fucache", Port: 8001, Resolver: r}, pool)
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+
+	found := false
+	for _, p := range d.Peers() {
+		found = found || p.Addr == self
+	}
+	if !found {
+		t.Fatalf("resolved peers %v should include self %s", d.Peers(), self)
+	}
+	local := 0
+	for i := 0; i < 100; i++ {
+		if _, ok := pool.PickPeer(fmt.Sprintf("key-%d", i)); !ok {
+			local++
+		}
+	}
+	if local == 0 || local == 100 {
+		t.Fatalf("keys should be split between self and the other peer, %d of 100 picked locally", local)
+	}
+}
@@ -0,0 +1,151 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fusidic/FuCache/pkg/cacheserver"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultPollInterval = 5 * time.Second
+
+// memberFile is the format of the membership file, e.g. in JSON:
+//
+//	{"peers": [{"addr": "http://localhost:8001", "weight": 2}, {"addr": "http://localhost:8002"}]}
+//
+// or in YAML:
+//
+//	peers:
+//	  - addr: http://localhost:8001
+//	    weight: 2
+type memberFile struct {
+	Peers []struct {
+		Addr   string `json:"addr" yaml:"addr"`
+		Weight int    `json:"weight" yaml:"weight"`
+	} `json:"peers" yaml:"peers"`
+}
+
+// File watches a JSON or YAML membership file and pushes its peers to an Updater.
+// 以轮询的方式检查文件的修改时间与大小，变化时重新读取；
+// 读取或解析失败时保留原有的节点列表，只有节点列表真正变化时才会调用 Updater
+type File struct {
+	path     string
+	interval time.Duration
+	updater  Updater
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	peers   []cacheserver.Peer
+
+	poller poller
+}
+
+// NewFile creates a File watching path every interval,
+// interval <= 0 means defaultPollInterval.
+// 文件格式由扩展名决定，.yaml 与 .yml 为 YAML，其余为 JSON
+func NewFile(path string, interval time.Duration, updater Updater) *File {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	return &File{
+		path:     path,
+		interval: interval,
+		updater:  updater,
+	}
+}
+
+// Load reads and parses the membership file.
+func (f *File) Load() ([]cacheserver.Peer, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	var mf memberFile
+	switch strings.ToLower(filepath.Ext(f.path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &mf)
+	default:
+		err = json.Unmarshal(data, &mf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %v", f.path, err)
+	}
+	peers := make([]cacheserver.Peer, 0, len(mf.Peers))
+	for _, p := range mf.Peers {
+		if p.Addr == "" {
+			return nil, fmt.Errorf("parse %s: peer without addr", f.path)
+		}
+		if p.Weight < 0 {
+			return nil, fmt.Errorf("parse %s: negative weight of %s", f.path, p.Addr)
+		}
+		peers = append(peers, cacheserver.Peer{Addr: p.Addr, Weight: p.Weight})
+	}
+	return peers, nil
+}
+
+// Start loads the file once, then keeps watching it in background until Stop is called.
+// 首次加载失败时返回错误，不会开始监听
+func (f *File) Start() error {
+	if err := f.reload(true); err != nil {
+		return err
+	}
+	f.poller.start(f.interval, func() {
+		if err := f.reload(false); err != nil {
+			log.Printf("[Discovery] reload %s: %v", f.path, err)
+		}
+	})
+	return nil
+}
+
+// Stop stops watching the file.
+func (f *File) Stop() {
+	f.poller.stop()
+}
+
+// Peers returns the peers last pushed to the Updater.
+func (f *File) Peers() []cacheserver.Peer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	peers := make([]cacheserver.Peer, len(f.peers))
+	copy(peers, f.peers)
+	return peers
+}
+
+// reload 在文件变化 (或 force 为 true) 时重新读取，并在节点列表变化时通知 Updater
+func (f *File) reload(force bool) error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	unchanged := info.ModTime().Equal(f.modTime) && info.Size() == f.size
+	f.mu.Unlock()
+	if unchanged && !force {
+		return nil
+	}
+
+	peers, err := f.Load()
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.modTime, f.size = info.ModTime(), info.Size()
+	if !force && samePeers(peers, f.peers) {
+		f.mu.Unlock()
+		return nil
+	}
+	f.peers = peers
+	f.mu.Unlock()
+	log.Printf("[Discovery] %d peers loaded from %s", len(peers), f.path)
+	f.updater.SetPeers(peers...)
+	return nil
+}
@@ -0,0 +1,148 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fusidic/FuCache/pkg/cacheserver"
+)
+
+type fakeUpdater struct {
+	mu    sync.Mutex
+	calls [][]cacheserver.Peer
+}
+
+func (u *fakeUpdater) SetPeers(peers ...cacheserver.Peer) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.calls = append(u.calls, peers)
+}
+
+func (u *fakeUpdater) last() (int, []cacheserver.Peer) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.calls) == 0 {
+		return 0, nil
+	}
+	return len(u.calls), u.calls[len(u.calls)-1]
+}
+
+// waitFor 等待 Updater 被调用 n 次
+func (u *fakeUpdater) waitFor(t *testing.T, n int) []cacheserver.Peer {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if calls, peers := u.last(); calls >= n {
+			return peers
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	calls, _ := u.last()
+	t.Fatalf("SetPeers called %d times, want %d", calls, n)
+	return nil
+}
+
+func writeFile(t *testing.T, path, data string, modTime time.Time) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 显式设置修改时间，避免文件系统时间精度导致变化未被发现
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestFileLoad(t *testing.T) {
+	dir := tempDir(t)
+	want := []cacheserver.Peer{
+		{Addr: "http://localhost:8001", Weight: 2},
+		{Addr: "http://localhost:8002"},
+	}
+	files := map[string]string{
+		"peers.json": `{"peers": [{"addr": "http://localhost:8001", "weight": 2}, {"addr": "http://localhost:8002"}]}`,
+		"peers.yaml": "peers:\n  - addr: http://localhost:8001\n    weight: 2\n  - addr: http://localhost:8002\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		writeFile(t, path, data, time.Now())
+		peers, err := NewFile(path, 0, &fakeUpdater{}).Load()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(peers, want) {
+			t.Errorf("%s: got %v, want %v", name, peers, want)
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	writeFile(t, bad, `{"peers": [{"weight": 1}]}`, time.Now())
+	if _, err := NewFile(bad, 0, &fakeUpdater{}).Load(); err == nil {
+		t.Errorf("expected error for peer without addr")
+	}
+}
+
+func TestFileWatch(t *testing.T) {
+	path := filepath.Join(tempDir(t), "peers.json")
+	start := time.Now().Add(-time.Hour)
+	writeFile(t, path, `{"peers": [{"addr": "a"}, {"addr": "b"}]}`, start)
+
+	u := &fakeUpdater{}
+	f := NewFile(path, 10*time.Millisecond, u)
+	if err := f.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+	if peers := u.waitFor(t, 1); len(peers) != 2 {
+		t.Fatalf("initial peers %v", peers)
+	}
+
+	// 内容变化会推送新的节点列表
+	writeFile(t, path, `{"peers": [{"addr": "a"}, {"addr": "c", "weight": 3}]}`, start.Add(time.Second))
+	peers := u.waitFor(t, 2)
+	want := []cacheserver.Peer{{Addr: "a"}, {Addr: "c", Weight: 3}}
+	if !reflect.DeepEqual(peers, want) {
+		t.Fatalf("got %v, want %v", peers, want)
+	}
+
+	// 只调整顺序，节点列表不变，不会推送
+	writeFile(t, path, `{"peers": [{"addr": "c", "weight": 3}, {"addr": "a"}]}`, start.Add(2*time.Second))
+	// 解析失败时保留原有节点列表
+	time.Sleep(50 * time.Millisecond)
+	writeFile(t, path, `{"peers": [`, start.Add(3*time.Second))
+	time.Sleep(50 * time.Millisecond)
+	if calls, _ := u.last(); calls != 2 {
+		t.Fatalf("SetPeers called %d times, want 2", calls)
+	}
+	if got := f.Peers(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// 修复后继续生效
+	writeFile(t, path, `{"peers": [{"addr": "d"}]}`, start.Add(4*time.Second))
+	if peers := u.waitFor(t, 3); len(peers) != 1 || peers[0].Addr != "d" {
+		t.Fatalf("got %v", peers)
+	}
+}
+
+func TestFileStartError(t *testing.T) {
+	f := NewFile(filepath.Join(tempDir(t), "missing.json"), 0, &fakeUpdater{})
+	if err := f.Start(); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+	f.Stop()
+}
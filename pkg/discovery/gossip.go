@@ -0,0 +1,667 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fusidic/FuCache/pkg/cacheserver"
+)
+
+// MemberState is the state of a member seen by Gossip.
+type MemberState int
+
+const (
+	// StateAlive 正常节点
+	StateAlive MemberState = iota
+	// StateSuspect 探测失败的节点，依旧留在哈希环中，超时未反驳则视为 StateDead
+	StateSuspect
+	// StateDead 故障或已离开的节点，会从哈希环中移除
+	StateDead
+)
+
+func (s MemberState) String() string {
+	switch s {
+	case StateAlive:
+		return "alive"
+	case StateSuspect:
+		return "suspect"
+	case StateDead:
+		return "dead"
+	}
+	return fmt.Sprintf("MemberState(%d)", int(s))
+}
+
+// Member is a node of the gossip cluster.
+// Incarnation 只能由节点自身增加，用于反驳对自己的怀疑，
+// 同一节点的状态以 Incarnation 更大者为准
+type Member struct {
+	Name        string      `json:"name"` // 节点在 Pool 中的地址，如 "http://localhost:8001"
+	Addr        string      `json:"addr"` // gossip 使用的 UDP 地址
+	Weight      int         `json:"weight,omitempty"`
+	State       MemberState `json:"state"`
+	Incarnation uint64      `json:"inc"`
+}
+
+// GossipConfig configures Gossip, zero fields are set to default values.
+type GossipConfig struct {
+	Name   string // 节点名称，即节点在 Pool 中的地址
+	Weight int
+	// BindAddr 为监听的 UDP 地址，如 "127.0.0.1:7946"
+	// AdvertiseAddr 为其他节点访问本节点使用的地址，默认为实际监听的地址
+	BindAddr      string
+	AdvertiseAddr string
+	// 每个 ProbeInterval 探测一个节点，ProbeTimeout 内无响应则请求 IndirectChecks 个节点代为探测
+	ProbeInterval  time.Duration
+	ProbeTimeout   time.Duration
+	IndirectChecks int
+	// 节点被怀疑超过 SuspicionTimeout 后视为故障
+	SuspicionTimeout time.Duration
+	// 每条状态变化被附带发送 RetransmitMult*log10(n+1) 次
+	RetransmitMult int
+	// 每个 ProbeInterval 额外向 GossipNodes 个节点发送待传播的状态变化
+	GossipNodes int
+	// dead 节点保留 DeadReapTimeout 后被删除；保留期间可以阻止过时的 alive 消息使其复活，
+	// 需要远大于状态变化的传播时间
+	DeadReapTimeout time.Duration
+}
+
+const (
+	defaultProbeInterval    = time.Second
+	defaultProbeTimeout     = 300 * time.Millisecond
+	defaultIndirectChecks   = 3
+	defaultSuspicionTimeout = 5 * time.Second
+	defaultRetransmitMult   = 4
+	defaultGossipNodes      = 3
+	defaultDeadReapTimeout  = time.Minute
+	// 每个消息最多附带的状态变化数量，避免超出 UDP 包大小
+	maxPiggyback  = 16
+	maxPacketSize = 65536
+)
+
+type msgType int
+
+const (
+	pingMsg msgType = iota
+	indirectPingMsg
+	ackMsg
+	joinMsg
+	syncMsg
+	gossipMsg
+)
+
+// message 为节点间的 UDP 消息，Updates 为附带 (piggyback) 传播的状态变化
+type message struct {
+	Type       msgType  `json:"type"`
+	Seq        uint64   `json:"seq,omitempty"`
+	Target     string   `json:"target,omitempty"`
+	TargetAddr string   `json:"target_addr,omitempty"`
+	Updates    []Member `json:"updates,omitempty"`
+}
+
+type memberInfo struct {
+	Member
+	suspectAt time.Time
+	deadAt    time.Time
+}
+
+type broadcast struct {
+	update    Member
+	transmits int
+}
+
+// Gossip maintains the membership of a cluster with a SWIM-style protocol over UDP,
+// and pushes the alive and suspect members to an Updater.
+// 每个 ProbeInterval 按随机顺序探测一个节点 (ping)，超时后通过其他节点间接探测 (ping-req)，
+// 均失败时将其标记为 suspect；状态变化附带在探测消息中传播，被怀疑的节点可以增加
+// Incarnation 反驳，超过 SuspicionTimeout 未反驳则标记为 dead 并从 Pool 中移除
+type Gossip struct {
+	cfg     GossipConfig
+	updater Updater
+	conn    *net.UDPConn
+
+	mu         sync.Mutex
+	members    map[string]*memberInfo // 包括本节点与 DeadReapTimeout 内的 dead 节点
+	probeOrder []string
+	probeIndex int
+	queue      []*broadcast
+	acks       map[uint64]func()
+	seq        uint64
+	leaving    bool
+	rand       *rand.Rand
+
+	notifyMu sync.Mutex
+	notified bool
+	peers    []cacheserver.Peer
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewGossip creates a Gossip, call Start to begin listening and probing.
+func NewGossip(cfg GossipConfig, updater Updater) *Gossip {
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = defaultProbeInterval
+	}
+	if cfg.ProbeTimeout <= 0 || cfg.ProbeTimeout >= cfg.ProbeInterval {
+		cfg.ProbeTimeout = cfg.ProbeInterval * 3 / 10
+	}
+	if cfg.IndirectChecks <= 0 {
+		cfg.IndirectChecks = defaultIndirectChecks
+	}
+	if cfg.SuspicionTimeout <= 0 {
+		cfg.SuspicionTimeout = defaultSuspicionTimeout
+	}
+	if cfg.RetransmitMult <= 0 {
+		cfg.RetransmitMult = defaultRetransmitMult
+	}
+	if cfg.GossipNodes <= 0 {
+		cfg.GossipNodes = defaultGossipNodes
+	}
+	if cfg.DeadReapTimeout <= 0 {
+		cfg.DeadReapTimeout = defaultDeadReapTimeout
+	}
+	return &Gossip{
+		cfg:     cfg,
+		updater: updater,
+		members: make(map[string]*memberInfo),
+		acks:    make(map[uint64]func()),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start listens on BindAddr and starts probing, the Updater is called with this node at once.
+func (g *Gossip) Start() error {
+	if g.cfg.Name == "" {
+		return fmt.Errorf("gossip: empty node name")
+	}
+	addr, err := net.ResolveUDPAddr("udp", g.cfg.BindAddr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	g.conn = conn
+	if g.cfg.AdvertiseAddr == "" {
+		g.cfg.AdvertiseAddr = conn.LocalAddr().String()
+	}
+
+	g.mu.Lock()
+	g.members[g.cfg.Name] = &memberInfo{Member: Member{
+		Name:   g.cfg.Name,
+		Addr:   g.cfg.AdvertiseAddr,
+		Weight: g.cfg.Weight,
+		State:  StateAlive,
+	}}
+	g.mu.Unlock()
+	g.notify()
+
+	g.wg.Add(2)
+	go g.receiveLoop()
+	go g.probeLoop()
+	return nil
+}
+
+// Addr returns the UDP address other nodes use to reach this node.
+func (g *Gossip) Addr() string {
+	return g.cfg.AdvertiseAddr
+}
+
+// Join contacts the seeds and fetches their member lists,
+// it succeeds as soon as one seed responds.
+func (g *Gossip) Join(seeds ...string) error {
+	seq, acked := g.expectAck()
+	defer g.removeAck(seq)
+
+	self := g.self()
+	for _, seed := range seeds {
+		g.send(seed, message{Type: joinMsg, Seq: seq, Updates: []Member{self}})
+	}
+	select {
+	case <-acked:
+		return nil
+	case <-time.After(3 * g.cfg.ProbeInterval):
+		return fmt.Errorf("gossip: no response from seeds %v", seeds)
+	case <-g.stop:
+		return fmt.Errorf("gossip: stopped")
+	}
+}
+
+// Leave announces that this node is leaving the cluster, then stops it.
+// 直接通知所有节点，其他节点无需等待故障检测即可移除本节点
+func (g *Gossip) Leave() {
+	g.mu.Lock()
+	g.leaving = true
+	self := g.members[g.cfg.Name]
+	self.State = StateDead
+	left := self.Member
+	var addrs []string
+	for name, m := range g.members {
+		if name != g.cfg.Name && m.State != StateDead {
+			addrs = append(addrs, m.Addr)
+		}
+	}
+	g.mu.Unlock()
+
+	for _, addr := range addrs {
+		g.send(addr, message{Type: gossipMsg, Updates: []Member{left}})
+	}
+	g.Stop()
+}
+
+// Stop stops this node without notifying others, they will detect it as failed.
+func (g *Gossip) Stop() {
+	g.stopOnce.Do(func() {
+		close(g.stop)
+		if g.conn != nil {
+			g.conn.Close()
+		}
+	})
+	g.wg.Wait()
+}
+
+// Members returns all known members sorted by name, including this node and dead members.
+func (g *Gossip) Members() []Member {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	members := make([]Member, 0, len(g.members))
+	for _, m := range g.members {
+		members = append(members, m.Member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+func (g *Gossip) self() Member {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.members[g.cfg.Name].Member
+}
+
+func (g *Gossip) receiveLoop() {
+	defer g.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := g.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-g.stop:
+				return
+			default:
+			}
+			log.Println("[Gossip] read:", err)
+			continue
+		}
+		var msg message
+		if err := json.Unmarshal(buf[:n], &msg); err != nil {
+			log.Println("[Gossip] invalid message from", from, err)
+			continue
+		}
+		g.handle(msg, from)
+	}
+}
+
+func (g *Gossip) handle(msg message, from *net.UDPAddr) {
+	g.applyAll(msg.Updates)
+
+	switch msg.Type {
+	case pingMsg:
+		// 地址相同但名称不同，说明是其他节点重启后复用了该地址
+		if msg.Target != g.cfg.Name {
+			return
+		}
+		g.send(from.String(), message{Type: ackMsg, Seq: msg.Seq})
+	case indirectPingMsg:
+		// 代为探测，收到目标的 ack 后转发给请求方
+		seq, acked := g.expectAck()
+		g.send(msg.TargetAddr, message{Type: pingMsg, Seq: seq, Target: msg.Target})
+		go func() {
+			defer g.removeAck(seq)
+			select {
+			case <-acked:
+				g.send(from.String(), message{Type: ackMsg, Seq: msg.Seq})
+			case <-time.After(g.cfg.ProbeTimeout):
+			case <-g.stop:
+			}
+		}()
+	case ackMsg, syncMsg:
+		g.mu.Lock()
+		fn := g.acks[msg.Seq]
+		g.mu.Unlock()
+		if fn != nil {
+			fn()
+		}
+	case joinMsg:
+		g.mu.Lock()
+		members := make([]Member, 0, len(g.members))
+		for _, m := range g.members {
+			members = append(members, m.Member)
+		}
+		g.mu.Unlock()
+		g.send(from.String(), message{Type: syncMsg, Seq: msg.Seq, Updates: members})
+	}
+}
+
+func (g *Gossip) probeLoop() {
+	defer g.wg.Done()
+	ticker := time.NewTicker(g.cfg.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+			g.checkSuspects()
+			g.reapDead()
+			if target, ok := g.nextProbeTarget(); ok {
+				g.probe(target)
+			}
+			g.gossip()
+		}
+	}
+}
+
+// probe 探测 target，直接探测与间接探测均失败时将其标记为 suspect
+func (g *Gossip) probe(target Member) {
+	seq, acked := g.expectAck()
+	defer g.removeAck(seq)
+
+	g.send(target.Addr, message{Type: pingMsg, Seq: seq, Target: target.Name})
+	select {
+	case <-acked:
+		return
+	case <-time.After(g.cfg.ProbeTimeout):
+	case <-g.stop:
+		return
+	}
+
+	// 直接探测超时，可能只是本节点与 target 之间的网络问题，请其他节点代为探测
+	for _, m := range g.randomMembers(g.cfg.IndirectChecks, target.Name) {
+		g.send(m.Addr, message{
+			Type:       indirectPingMsg,
+			Seq:        seq,
+			Target:     target.Name,
+			TargetAddr: target.Addr,
+		})
+	}
+	select {
+	case <-acked:
+		return
+	case <-time.After(g.cfg.ProbeInterval - g.cfg.ProbeTimeout):
+	case <-g.stop:
+		return
+	}
+
+	log.Printf("[Gossip] suspect %s", target.Name)
+	target.State = StateSuspect
+	g.applyAll([]Member{target})
+}
+
+// checkSuspects 将超时未反驳的 suspect 节点标记为 dead
+func (g *Gossip) checkSuspects() {
+	var dead []Member
+	g.mu.Lock()
+	for _, m := range g.members {
+		if m.State == StateSuspect && time.Since(m.suspectAt) > g.cfg.SuspicionTimeout {
+			d := m.Member
+			d.State = StateDead
+			dead = append(dead, d)
+		}
+	}
+	g.mu.Unlock()
+	for _, m := range dead {
+		log.Printf("[Gossip] %s is dead", m.Name)
+	}
+	g.applyAll(dead)
+}
+
+// reapDead 删除超过 DeadReapTimeout 的 dead 节点，避免节点列表无限增长
+func (g *Gossip) reapDead() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for name, m := range g.members {
+		if name != g.cfg.Name && m.State == StateDead && time.Since(m.deadAt) > g.cfg.DeadReapTimeout {
+			delete(g.members, name)
+		}
+	}
+}
+
+// gossip 将待传播的状态变化发送给随机的几个节点，加快传播速度
+func (g *Gossip) gossip() {
+	g.mu.Lock()
+	pending := len(g.queue)
+	g.mu.Unlock()
+	if pending == 0 {
+		return
+	}
+	for _, m := range g.randomMembers(g.cfg.GossipNodes, "") {
+		g.send(m.Addr, message{Type: gossipMsg})
+	}
+}
+
+// nextProbeTarget 按随机顺序轮流返回需要探测的节点，一轮结束后重新打乱顺序
+func (g *Gossip) nextProbeTarget() (Member, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for shuffled := false; ; {
+		for g.probeIndex < len(g.probeOrder) {
+			name := g.probeOrder[g.probeIndex]
+			g.probeIndex++
+			if m, ok := g.members[name]; ok && m.State != StateDead {
+				return m.Member, true
+			}
+		}
+		if shuffled {
+			return Member{}, false
+		}
+		g.probeOrder = g.probeOrder[:0]
+		for name, m := range g.members {
+			if name != g.cfg.Name && m.State != StateDead {
+				g.probeOrder = append(g.probeOrder, name)
+			}
+		}
+		g.rand.Shuffle(len(g.probeOrder), func(i, j int) {
+			g.probeOrder[i], g.probeOrder[j] = g.probeOrder[j], g.probeOrder[i]
+		})
+		g.probeIndex = 0
+		shuffled = true
+	}
+}
+
+// randomMembers 返回最多 n 个随机的存活节点，不包括本节点与 exclude
+func (g *Gossip) randomMembers(n int, exclude string) []Member {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var members []Member
+	for name, m := range g.members {
+		if name != g.cfg.Name && name != exclude && m.State != StateDead {
+			members = append(members, m.Member)
+		}
+	}
+	g.rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+	if len(members) > n {
+		members = members[:n]
+	}
+	return members
+}
+
+func (g *Gossip) applyAll(updates []Member) {
+	if len(updates) == 0 {
+		return
+	}
+	changed := false
+	g.mu.Lock()
+	for _, u := range updates {
+		if g.apply(u) {
+			changed = true
+		}
+	}
+	g.mu.Unlock()
+	if changed {
+		g.notify()
+	}
+}
+
+// apply 合并一条状态变化，返回 Pool 中的节点列表是否需要更新，调用方需持有 g.mu
+// 规则与 SWIM 一致：alive 需要更大的 Incarnation 才能覆盖 suspect 与 dead，
+// suspect 可以覆盖相同 Incarnation 的 alive，dead 可以覆盖相同 Incarnation 的任何状态
+func (g *Gossip) apply(u Member) bool {
+	if u.Name == g.cfg.Name {
+		self := g.members[u.Name]
+		if self == nil || g.leaving {
+			return false
+		}
+		// 反驳其他节点对本节点的怀疑
+		if u.State != StateAlive && u.Incarnation >= self.Incarnation {
+			self.Incarnation = u.Incarnation + 1
+			g.enqueue(self.Member)
+		}
+		return false
+	}
+
+	m, ok := g.members[u.Name]
+	if !ok {
+		switch u.State {
+		case StateAlive:
+			g.members[u.Name] = &memberInfo{Member: u}
+			g.enqueue(u)
+			return true
+		case StateDead:
+			// 记录下来，避免过时的 alive 消息使其复活
+			g.members[u.Name] = &memberInfo{Member: u, deadAt: time.Now()}
+		}
+		return false
+	}
+
+	switch u.State {
+	case StateAlive:
+		if u.Incarnation <= m.Incarnation {
+			return false
+		}
+	case StateSuspect:
+		if m.State == StateDead || u.Incarnation < m.Incarnation ||
+			(u.Incarnation == m.Incarnation && m.State == StateSuspect) {
+			return false
+		}
+		m.suspectAt = time.Now()
+	case StateDead:
+		if m.State == StateDead || u.Incarnation < m.Incarnation {
+			return false
+		}
+		m.deadAt = time.Now()
+	}
+	changed := (m.State == StateDead) != (u.State == StateDead) || m.Weight != u.Weight
+	m.Member = u
+	g.enqueue(u)
+	return changed
+}
+
+// enqueue 加入待传播的状态变化，同一节点只保留最新的一条，调用方需持有 g.mu
+func (g *Gossip) enqueue(u Member) {
+	for i, b := range g.queue {
+		if b.update.Name == u.Name {
+			g.queue = append(g.queue[:i], g.queue[i+1:]...)
+			break
+		}
+	}
+	g.queue = append(g.queue, &broadcast{update: u})
+}
+
+// piggyback 返回附带在消息中的状态变化，优先发送次数少的，调用方需持有 g.mu
+func (g *Gossip) piggyback() []Member {
+	if len(g.queue) == 0 {
+		return nil
+	}
+	limit := g.cfg.RetransmitMult * int(math.Ceil(math.Log10(float64(len(g.members)+1))))
+	sort.SliceStable(g.queue, func(i, j int) bool { return g.queue[i].transmits < g.queue[j].transmits })
+	n := len(g.queue)
+	if n > maxPiggyback {
+		n = maxPiggyback
+	}
+	updates := make([]Member, 0, n)
+	for _, b := range g.queue[:n] {
+		updates = append(updates, b.update)
+		b.transmits++
+	}
+	left := g.queue[:0]
+	for _, b := range g.queue {
+		if b.transmits < limit {
+			left = append(left, b)
+		}
+	}
+	g.queue = left
+	return updates
+}
+
+func (g *Gossip) send(addr string, msg message) {
+	g.mu.Lock()
+	msg.Updates = append(msg.Updates, g.piggyback()...)
+	g.mu.Unlock()
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		log.Println("[Gossip] resolve:", err)
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("[Gossip] marshal:", err)
+		return
+	}
+	if _, err := g.conn.WriteToUDP(data, udpAddr); err != nil {
+		select {
+		case <-g.stop:
+		default:
+			log.Println("[Gossip] write:", err)
+		}
+	}
+}
+
+// expectAck 注册一个序号，返回的 channel 在收到该序号的 ack 后关闭
+func (g *Gossip) expectAck() (uint64, <-chan struct{}) {
+	acked := make(chan struct{})
+	var once sync.Once
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.seq++
+	g.acks[g.seq] = func() { once.Do(func() { close(acked) }) }
+	return g.seq, acked
+}
+
+func (g *Gossip) removeAck(seq uint64) {
+	g.mu.Lock()
+	delete(g.acks, seq)
+	g.mu.Unlock()
+}
+
+// notify 将本节点与未故障的节点推送给 Updater，节点列表未变化时不推送
+func (g *Gossip) notify() {
+	g.notifyMu.Lock()
+	defer g.notifyMu.Unlock()
+
+	g.mu.Lock()
+	peers := make([]cacheserver.Peer, 0, len(g.members))
+	for _, m := range g.members {
+		if m.State != StateDead {
+			peers = append(peers, cacheserver.Peer{Addr: m.Name, Weight: m.Weight})
+		}
+	}
+	g.mu.Unlock()
+
+	if g.notified && samePeers(peers, g.peers) {
+		return
+	}
+	g.notified = true
+	g.peers = peers
+	g.updater.SetPeers(sortedPeers(peers)...)
+}
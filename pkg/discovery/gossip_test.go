@@ -0,0 +1,174 @@
+package discovery
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testGossipConfig 返回测试使用的配置，时间足够短以便快速检测故障
+func testGossipConfig(name string) GossipConfig {
+	return GossipConfig{
+		Name:             name,
+		BindAddr:         "127.0.0.1:0",
+		ProbeInterval:    50 * time.Millisecond,
+		ProbeTimeout:     25 * time.Millisecond,
+		SuspicionTimeout: 300 * time.Millisecond,
+	}
+}
+
+func startGossip(t *testing.T, name string, seeds ...string) (*Gossip, *fakeUpdater) {
+	t.Helper()
+	return startGossipConfig(t, testGossipConfig(name), seeds...)
+}
+
+func startGossipConfig(t *testing.T, cfg GossipConfig, seeds ...string) (*Gossip, *fakeUpdater) {
+	t.Helper()
+	u := &fakeUpdater{}
+	g := NewGossip(cfg, u)
+	if err := g.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(g.Stop)
+	if len(seeds) > 0 {
+		if err := g.Join(seeds...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return g, u
+}
+
+// startCluster 启动 n 个节点，configure 用于修改各节点的配置
+func startCluster(t *testing.T, n int, configure ...func(*GossipConfig)) ([]*Gossip, []*fakeUpdater) {
+	t.Helper()
+	nodes := make([]*Gossip, n)
+	updaters := make([]*fakeUpdater, n)
+	for i := range nodes {
+		var seeds []string
+		if i > 0 {
+			seeds = []string{nodes[0].Addr()}
+		}
+		cfg := testGossipConfig(fmt.Sprintf("http://node%d", i))
+		for _, f := range configure {
+			f(&cfg)
+		}
+		nodes[i], updaters[i] = startGossipConfig(t, cfg, seeds...)
+	}
+	for i, u := range updaters {
+		waitPeers(t, u, n, fmt.Sprintf("node%d", i))
+	}
+	return nodes, updaters
+}
+
+// waitPeers 等待 Updater 最后一次收到的节点数为 n
+func waitPeers(t *testing.T, u *fakeUpdater, n int, name string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, peers := u.last(); len(peers) == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	_, peers := u.last()
+	t.Fatalf("%s has peers %v, want %d peers", name, peers, n)
+}
+
+func memberOf(g *Gossip, name string) (Member, bool) {
+	for _, m := range g.Members() {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return Member{}, false
+}
+
+func TestGossipJoin(t *testing.T) {
+	nodes, updaters := startCluster(t, 4)
+	_, peers := updaters[3].last()
+	for i, p := range peers {
+		if want := fmt.Sprintf("http://node%d", i); p.Addr != want {
+			t.Errorf("peer %d is %s, want %s", i, p.Addr, want)
+		}
+	}
+	for _, m := range nodes[1].Members() {
+		if m.State != StateAlive {
+			t.Errorf("member %s is %s", m.Name, m.State)
+		}
+	}
+}
+
+func TestGossipFailureDetection(t *testing.T) {
+	nodes, updaters := startCluster(t, 3)
+	// 直接停止，不通知其他节点
+	nodes[2].Stop()
+	for i := 0; i < 2; i++ {
+		waitPeers(t, updaters[i], 2, fmt.Sprintf("node%d", i))
+		if m, _ := memberOf(nodes[i], "http://node2"); m.State != StateDead {
+			t.Errorf("node%d sees node2 %s, want dead", i, m.State)
+		}
+	}
+}
+
+func TestGossipLeave(t *testing.T) {
+	// 故障检测远慢于 waitPeers 的等待时间，节点只能通过主动离开的通知被移除
+	nodes, updaters := startCluster(t, 3, func(cfg *GossipConfig) {
+		cfg.SuspicionTimeout = time.Minute
+	})
+	nodes[1].Leave()
+	waitPeers(t, updaters[0], 2, "node0")
+	waitPeers(t, updaters[2], 2, "node2")
+}
+
+func TestGossipReapDead(t *testing.T) {
+	nodes, updaters := startCluster(t, 3, func(cfg *GossipConfig) {
+		cfg.DeadReapTimeout = 100 * time.Millisecond
+	})
+	nodes[2].Leave()
+	waitPeers(t, updaters[0], 2, "node0")
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if _, ok := memberOf(nodes[0], "http://node2"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dead member should be reaped after DeadReapTimeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGossipRefute(t *testing.T) {
+	nodes, updaters := startCluster(t, 2)
+	// node1 错误地怀疑 node0，node0 收到后增加 Incarnation 进行反驳
+	m, _ := memberOf(nodes[1], "http://node0")
+	m.State = StateSuspect
+	nodes[1].applyAll([]Member{m})
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if m, _ := memberOf(nodes[1], "http://node0"); m.State == StateAlive && m.Incarnation > 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if m, _ := memberOf(nodes[1], "http://node0"); m.State != StateAlive || m.Incarnation == 0 {
+		t.Fatalf("node1 sees node0 %s with incarnation %d", m.State, m.Incarnation)
+	}
+	// 超过 SuspicionTimeout 后 node0 依旧在节点列表中
+	time.Sleep(2 * nodes[1].cfg.SuspicionTimeout)
+	if _, peers := updaters[1].last(); len(peers) != 2 {
+		t.Fatalf("node1 has peers %v", peers)
+	}
+}
+
+func TestGossipJoinError(t *testing.T) {
+	g, _ := startGossip(t, "http://alone")
+	other, _ := startGossip(t, "http://other")
+	addr := other.Addr()
+	other.Stop()
+	if err := g.Join(addr); err == nil {
+		t.Fatal("expected error joining a stopped seed")
+	}
+}
@@ -0,0 +1,314 @@
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/fusidic/FuCache/proto/cachepb"
+)
+
+// BatchGetter is a Getter which can load many keys from the data source in one call,
+// GetMany uses it to load the keys owned by this node.
+// 返回的 values 与 errs 需要与 keys 一一对应，errs 为 nil 表示全部成功
+type BatchGetter interface {
+	Getter
+	GetMany(ctx context.Context, keys []string) (values [][]byte, errs []error)
+}
+
+// ExpiringBatchGetter is a BatchGetter which also returns the expiration time of each value,
+// a zero time means the value never expires.
+type ExpiringBatchGetter interface {
+	BatchGetter
+	GetManyWithExpire(ctx context.Context, keys []string) (values [][]byte, expires []time.Time, errs []error)
+}
+
+// BatchGetterFunc implements BatchGetter.
+type BatchGetterFunc func(ctx context.Context, keys []string) ([][]byte, []error)
+
+// Get implements Getter.Get()
+func (f BatchGetterFunc) Get(key string) ([]byte, error) {
+	values, errs := f(context.Background(), []string{key})
+	return batchResult(values, errs, 0)
+}
+
+// GetMany implements BatchGetter.GetMany()
+func (f BatchGetterFunc) GetMany(ctx context.Context, keys []string) ([][]byte, []error) {
+	return f(ctx, keys)
+}
+
+// batchResult 返回 BatchGetter 结果中第 i 个 key 的值
+func batchResult(values [][]byte, errs []error, i int) ([]byte, error) {
+	if i < len(errs) && errs[i] != nil {
+		return nil, errs[i]
+	}
+	if i >= len(values) {
+		return nil, errors.New("batch getter returned no value")
+	}
+	return values[i], nil
+}
+
+// Result is the value or error of a key returned by GetMany.
+type Result struct {
+	Key   string
+	Value ByteView
+	Err   error
+}
+
+// GetMany gets the values of many keys, results are in the same order as keys.
+func (g *Group) GetMany(keys []string) []Result {
+	return g.GetManyContext(context.Background(), keys)
+}
+
+// GetManyContext gets the values of many keys like GetMany, ctx is passed to peers and the Getter.
+// 未命中的 key 按拥有者划分：每个支持 BatchPeerGetter 的远程节点只发送一次请求，
+// 本节点拥有的 key 通过 BatchGetter 一次加载，其余 key 与 Get 一样逐个加载
+func (g *Group) GetManyContext(ctx context.Context, keys []string) []Result {
+	results := make([]Result, len(keys))
+	// 重复的 key 只查找一次
+	indexes := make(map[string][]int, len(keys))
+	var unique []string
+	for i, key := range keys {
+		results[i].Key = key
+		if key == "" {
+			results[i].Err = fmt.Errorf("Require a key")
+			continue
+		}
+		if _, ok := indexes[key]; !ok {
+			unique = append(unique, key)
+		}
+		indexes[key] = append(indexes[key], i)
+	}
+	// 不同 key 对应的下标互不相同，可以并发调用
+	set := func(key string, value ByteView, err error) {
+		for _, i := range indexes[key] {
+			results[i].Value, results[i].Err = value, err
+		}
+	}
+
+	var misses []string
+	for _, key := range unique {
+		g.stats.Gets.Add(1)
+		if v, ok := g.lookupCache(key); ok {
+			g.stats.CacheHits.Add(1)
+			g.maybeRefresh(key, v)
+			set(key, v, nil)
+			continue
+		}
+		misses = append(misses, key)
+	}
+
+	var (
+		byPeer = make(map[BatchPeerGetter][]string)
+		local  []string
+		single []string
+	)
+	for _, key := range misses {
+		peers := g.routePeers(ctx, key)
+		if len(peers) == 0 {
+			local = append(local, key)
+			continue
+		}
+		if bp, ok := peers[0].(BatchPeerGetter); ok {
+			byPeer[bp] = append(byPeer[bp], key)
+		} else {
+			single = append(single, key)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for peer, keys := range byPeer {
+		wg.Add(1)
+		go func(peer BatchPeerGetter, keys []string) {
+			defer wg.Done()
+			g.getManyFromPeer(ctx, peer, keys, set)
+		}(peer, keys)
+	}
+	if len(local) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.getManyLocally(ctx, local, set)
+		}()
+	}
+	g.loadEach(ctx, single, set, &wg)
+	wg.Wait()
+	return results
+}
+
+// loadEach 并发地逐个加载 keys，与 Get 一样经过 singleflight、备用节点与本地加载
+func (g *Group) loadEach(ctx context.Context, keys []string, set func(string, ByteView, error), wg *sync.WaitGroup) {
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			value, err := g.load(ctx, key)
+			set(key, value, err)
+		}(key)
+	}
+}
+
+// getManyFromPeer 向拥有者批量请求，整个请求失败时逐个加载
+func (g *Group) getManyFromPeer(ctx context.Context, peer BatchPeerGetter, keys []string, set func(string, ByteView, error)) {
+	req := &cachepb.BatchRequest{Group: g.name, Keys: keys}
+	res := &cachepb.BatchResponse{}
+	err := peer.GetMany(ctx, req, res)
+	if err == nil && len(res.Results) != len(keys) {
+		err = fmt.Errorf("peer returned %d results for %d keys", len(res.Results), len(keys))
+	}
+	if err != nil {
+		g.stats.PeerErrors.Add(1)
+		log.Println("[GroupCache] Failed to get many from peer", err)
+		var wg sync.WaitGroup
+		g.loadEach(ctx, keys, set, &wg)
+		wg.Wait()
+		return
+	}
+
+	for i, r := range res.Results {
+		g.stats.Loads.Add(1)
+		g.stats.LoadsDeduped.Add(1)
+		// 拥有者已经尝试过加载，单个 key 的错误直接返回
+		if r.Error != "" {
+			g.stats.PeerErrors.Add(1)
+			set(keys[i], ByteView{}, errors.New(r.Error))
+			continue
+		}
+		g.stats.PeerLoads.Add(1)
+		value := ByteView{b: r.Value, e: unixNano(r.Expire)}
+		if rand.Intn(hotCachePopulateChance) == 0 {
+			g.populateCache(keys[i], value, &g.hotCache)
+		}
+		set(keys[i], value, nil)
+	}
+}
+
+// getManyLocally 加载本节点拥有的 keys，与 Get 一样经过 singleflight，与同一个 key 的并发加载合并；
+// 由本次调用加载的 keys 在全部确定后通过一次 BatchGetter.GetMany 加载，不支持时逐个加载
+func (g *Group) getManyLocally(ctx context.Context, keys []string, set func(string, ByteView, error)) {
+	var wg sync.WaitGroup
+	bg, ok := g.getter.(BatchGetter)
+	if !ok {
+		for _, key := range keys {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				value, err := g.loadWith(ctx, key, func(ctx context.Context) (interface{}, error) {
+					g.stats.LoadsDeduped.Add(1)
+					value, err := g.getLocally(ctx, key)
+					return g.localResult(key, value, err)
+				}, nil)
+				set(key, value, err)
+			}(key)
+		}
+		wg.Wait()
+		return
+	}
+
+	// 启用 WithBatching 时每次调用不超过 MaxSize 个 key
+	size := len(keys)
+	if g.batcher != nil && g.batcher.cfg.MaxSize < size {
+		size = g.batcher.cfg.MaxSize
+	}
+	lb := &localBatch{pending: len(keys)}
+	flush := func(calls []*batchCall) {
+		for len(calls) > 0 {
+			n := size
+			if n > len(calls) {
+				n = len(calls)
+			}
+			g.flushBatch(bg, calls[:n])
+			calls = calls[n:]
+		}
+	}
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			// 每个 key 只确定一次，返回 false 表示已经确定过
+			var (
+				mu      sync.Mutex
+				settled bool
+			)
+			settle := func(c *batchCall) bool {
+				mu.Lock()
+				if settled {
+					mu.Unlock()
+					return false
+				}
+				settled = true
+				mu.Unlock()
+				if calls := lb.settle(c); len(calls) > 0 {
+					go flush(calls)
+				}
+				return true
+			}
+			value, err := g.loadWith(ctx, key, func(ctx context.Context) (interface{}, error) {
+				g.stats.LoadsDeduped.Add(1)
+				c := &batchCall{ctx: ctx, key: key, done: make(chan struct{})}
+				// 调用方在加载开始前已经离开，这一批可能已经加载，单独加载该 key
+				if !settle(c) {
+					g.flushBatch(bg, []*batchCall{c})
+				}
+				<-c.done
+				var value ByteView
+				if c.err == nil {
+					value = ByteView{b: cloneBytes(c.value), e: c.expire}
+					g.populateCache(key, value, &g.mainCache)
+				}
+				return g.localResult(key, value, c.err)
+			}, func() { settle(nil) })
+			// 调用方已取消时，key 不再计入这一批
+			settle(nil)
+			set(key, value, err)
+		}(key)
+	}
+	wg.Wait()
+}
+
+// localBatch 收集一次 GetMany 中由本次调用加载的 keys
+type localBatch struct {
+	mu      sync.Mutex
+	pending int
+	calls   []*batchCall
+}
+
+// settle 标记一个 key 已确定由谁加载，c 为 nil 表示不由本次调用加载；
+// 所有 key 确定后返回需要加载的调用，否则返回 nil
+func (b *localBatch) settle(c *batchCall) []*batchCall {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c != nil {
+		b.calls = append(b.calls, c)
+	}
+	b.pending--
+	if b.pending > 0 {
+		return nil
+	}
+	return b.calls
+}
+
+// localResult 统计本地加载的结果，失败时尝试返回最后一个有效值
+func (g *Group) localResult(key string, value ByteView, err error) (interface{}, error) {
+	if err != nil {
+		g.stats.LocalLoadErrs.Add(1)
+		if v, ok := g.staleOnError(key, err); ok {
+			return v, nil
+		}
+		return ByteView{}, err
+	}
+	g.stats.LocalLoads.Add(1)
+	return value, nil
+}
+
+// unixNano 将 Unix 纳秒时间戳转换为 time.Time，0 表示永不过期
+func unixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
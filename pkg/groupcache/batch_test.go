@@ -0,0 +1,280 @@
+package groupcache
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fusidic/FuCache/proto/cachepb"
+)
+
+// batchPeer 记录收到的批量请求，用于模拟支持 GetMany 的远程节点
+type batchPeer struct {
+	fakePeer
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (p *batchPeer) GetMany(ctx context.Context, in *cachepb.BatchRequest, out *cachepb.BatchResponse) error {
+	p.mu.Lock()
+	p.batches = append(p.batches, in.GetKeys())
+	p.mu.Unlock()
+	if p.fail {
+		return fmt.Errorf("peer unavailable")
+	}
+	for _, key := range in.GetKeys() {
+		r := &cachepb.BatchResponse_Result{}
+		if v, ok := db[key]; ok {
+			r.Value = []byte(v)
+		} else {
+			r.Error = key + " not exist"
+		}
+		out.Results = append(out.Results, r)
+	}
+	return nil
+}
+
+// mapPicker 按 key 选择节点，不在其中的 key 由本节点加载
+type mapPicker map[string]PeerGetter
+
+func (p mapPicker) PickPeer(key string) (PeerGetter, bool) {
+	peer, ok := p[key]
+	return peer, ok
+}
+
+func dbBatchGetter(calls *[][]string) BatchGetter {
+	return BatchGetterFunc(func(ctx context.Context, keys []string) ([][]byte, []error) {
+		*calls = append(*calls, keys)
+		values := make([][]byte, len(keys))
+		errs := make([]error, len(keys))
+		for i, key := range keys {
+			if v, ok := db[key]; ok {
+				values[i] = []byte(v)
+			} else {
+				errs[i] = fmt.Errorf("%s not exist", key)
+			}
+		}
+		return values, errs
+	})
+}
+
+func TestGetManyLocal(t *testing.T) {
+	var calls [][]string
+	g := NewGroup("batch-local", 2<<10, dbBatchGetter(&calls))
+
+	results := g.GetMany([]string{"Tom", "Jack", "Tom", "", "unknown"})
+	for i, expect := range []string{"630", "234", "630"} {
+		if r := results[i]; r.Err != nil || r.Value.String() != expect {
+			t.Fatalf("result %d: %+v, expect %s", i, r, expect)
+		}
+	}
+	if results[3].Err == nil || results[4].Err == nil || results[4].Key != "unknown" {
+		t.Fatalf("empty and unknown keys should fail, got %+v %+v", results[3], results[4])
+	}
+	// 重复的 key 只加载一次，所有 key 在一次调用中加载
+	if len(calls) == 1 {
+		sort.Strings(calls[0])
+	}
+	if expect := [][]string{{"Jack", "Tom", "unknown"}}; !reflect.DeepEqual(calls, expect) {
+		t.Fatalf("GetMany calls %v, expect %v", calls, expect)
+	}
+
+	g.GetMany([]string{"Tom", "Jack"})
+	if len(calls) != 1 {
+		t.Fatalf("cached keys should not be loaded again, calls %v", calls)
+	}
+	if s := g.Stats(); s.LocalLoads != 2 || s.LocalLoadErrs != 1 || s.CacheHits != 2 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestGetManyPeers(t *testing.T) {
+	a, b, single := &batchPeer{}, &batchPeer{}, &fakePeer{}
+	var calls [][]string
+	g := NewGroup("batch-peers", 2<<10, dbBatchGetter(&calls))
+	g.RegisterPeers(mapPicker{"Tom": a, "unknown": a, "Jack": b, "single": single})
+
+	results := g.GetMany([]string{"Tom", "Jack", "unknown", "fusidic", "single"})
+	if results[0].Value.String() != "630" || results[1].Value.String() != "234" || results[3].Value.String() != "556" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if err := results[2].Err; err == nil || !strings.Contains(err.Error(), "not exist") {
+		t.Fatalf("error of unknown should come from the peer, got %v", err)
+	}
+	// 每个节点只收到一个批量请求，不支持批量的节点逐个请求
+	if expect := [][]string{{"Tom", "unknown"}}; !reflect.DeepEqual(a.batches, expect) {
+		t.Fatalf("peer a got %v, expect %v", a.batches, expect)
+	}
+	if expect := [][]string{{"Jack"}}; !reflect.DeepEqual(b.batches, expect) {
+		t.Fatalf("peer b got %v, expect %v", b.batches, expect)
+	}
+	if single.gets != 1 {
+		t.Fatalf("peer without GetMany should get 1 request, got %d", single.gets)
+	}
+	if expect := [][]string{{"fusidic"}}; !reflect.DeepEqual(calls, expect) {
+		t.Fatalf("local GetMany calls %v, expect %v", calls, expect)
+	}
+}
+
+func TestGetManyPeerFailure(t *testing.T) {
+	peer := &batchPeer{fakePeer: fakePeer{fail: true}}
+	var loads int32
+	g := NewGroup("batch-peer-failure", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			atomic.AddInt32(&loads, 1)
+			return []byte(db[key]), nil
+		}))
+	g.RegisterPeers(mapPicker{"Tom": peer, "Jack": peer})
+
+	// 批量请求失败后逐个加载，节点依旧不可用时从本地加载
+	results := g.GetMany([]string{"Tom", "Jack"})
+	for i, expect := range []string{"630", "234"} {
+		if r := results[i]; r.Err != nil || r.Value.String() != expect {
+			t.Fatalf("result %d: %+v, expect %s", i, r, expect)
+		}
+	}
+	if len(peer.batches) != 1 || loads != 2 {
+		t.Fatalf("expect 1 batch and 2 local loads, got %d and %d", len(peer.batches), loads)
+	}
+}
+
+func TestGetManyLocalSharesLoads(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls [][]string
+	)
+	block := make(chan struct{})
+	expire := time.Now().Add(time.Hour)
+	g := NewGroup("batch-local-shared", 2<<10, expiringBatchGetterFunc(
+		func(ctx context.Context, keys []string) ([][]byte, []time.Time, []error) {
+			mu.Lock()
+			calls = append(calls, keys)
+			mu.Unlock()
+			if len(keys) == 1 && keys[0] == "Tom" {
+				<-block
+			}
+			values := make([][]byte, len(keys))
+			expires := make([]time.Time, len(keys))
+			for i, key := range keys {
+				values[i], expires[i] = []byte(db[key]), expire
+			}
+			return values, expires, nil
+		}))
+
+	// Get 加载 Tom 期间，GetMany 等待同一次加载，只加载 Jack
+	got := make(chan ByteView)
+	go func() {
+		v, _ := g.Get("Tom")
+		got <- v
+	}()
+	for {
+		mu.Lock()
+		n := len(calls)
+		mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan []Result)
+	go func() { done <- g.GetMany([]string{"Tom", "Jack"}) }()
+	time.Sleep(20 * time.Millisecond)
+	close(block)
+	results := <-done
+	<-got
+	if results[0].Value.String() != "630" || results[1].Value.String() != "234" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if expect := [][]string{{"Tom"}, {"Jack"}}; !reflect.DeepEqual(calls, expect) {
+		t.Fatalf("GetMany calls %v, expect %v", calls, expect)
+	}
+	// 批量加载的值保留 ExpiringBatchGetter 返回的过期时间
+	if e := results[1].Value.Expire(); !e.Equal(expire) {
+		t.Fatalf("expire of Jack = %v, expect %v", e, expire)
+	}
+	if s := g.Stats(); s.LocalLoads != 2 || s.LoadsDeduped != 2 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+type expiringBatchGetterFunc func(ctx context.Context, keys []string) ([][]byte, []time.Time, []error)
+
+func (f expiringBatchGetterFunc) Get(key string) ([]byte, error) {
+	values, _, errs := f(context.Background(), []string{key})
+	return batchResult(values, errs, 0)
+}
+
+func (f expiringBatchGetterFunc) GetMany(ctx context.Context, keys []string) ([][]byte, []error) {
+	values, _, errs := f(ctx, keys)
+	return values, errs
+}
+
+func (f expiringBatchGetterFunc) GetManyWithExpire(ctx context.Context, keys []string) ([][]byte, []time.Time, []error) {
+	return f(ctx, keys)
+}
+
+// cancelAfterCheck 在第一次 Err 检查通过后立即取消，模拟在加载开始前到达的取消
+type cancelAfterCheck struct {
+	context.Context
+	cancel  context.CancelFunc
+	checked int32
+}
+
+func (c *cancelAfterCheck) Err() error {
+	if atomic.CompareAndSwapInt32(&c.checked, 0, 1) {
+		c.cancel()
+		return nil
+	}
+	return c.Context.Err()
+}
+
+func TestGetManyLocalCanceledMidCall(t *testing.T) {
+	g := NewGroup("batch-local-canceled", 2<<10, BatchGetterFunc(
+		func(ctx context.Context, keys []string) ([][]byte, []error) {
+			values := make([][]byte, len(keys))
+			for i, key := range keys {
+				values[i] = []byte("v-" + key)
+			}
+			return values, nil
+		}))
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("k%d", i)
+		ctx, cancel := context.WithCancel(context.Background())
+		g.GetManyContext(&cancelAfterCheck{Context: ctx, cancel: cancel}, []string{key})
+		time.Sleep(10 * time.Millisecond)
+
+		// 取消的调用不能让 key 的加载一直挂起
+		done := make(chan error, 1)
+		go func() {
+			_, err := g.Get(key)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Get(%s) after canceled GetMany: %v", key, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Get(%s) hangs after canceled GetMany", key)
+		}
+	}
+	// 已经开始的加载都会结束，不会一直等待不会到来的批量加载
+	deadline := time.Now().Add(time.Second)
+	for {
+		s := g.Stats()
+		if s.LocalLoads+s.LocalLoadErrs == s.LoadsDeduped {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d loads never finished, stats %+v", s.LoadsDeduped-s.LocalLoads-s.LocalLoadErrs, s)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,157 @@
+package groupcache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultBatchWindow 为合并未命中请求时等待的默认时间
+	defaultBatchWindow = 2 * time.Millisecond
+	// defaultMaxBatchSize 为一次 GetMany 调用的默认最大 key 数
+	defaultMaxBatchSize = 100
+)
+
+// BatchConfig configures coalescing of concurrent local loads into BatchGetter.GetMany.
+// 本节点并发未命中的 key 在 Window 内累积，窗口结束或达到 MaxSize 时合并为一次 GetMany 调用；
+// 合并发生在 singleflight 之后，同一个 key 在一批中只出现一次
+type BatchConfig struct {
+	// Window 为第一个 key 加入后等待更多 key 的时间，为 0 时使用 defaultBatchWindow
+	Window time.Duration
+	// MaxSize 为一批的最大 key 数，为 0 时使用 defaultMaxBatchSize
+	MaxSize int
+}
+
+// WithBatching coalesces concurrent misses of the group into calls of BatchGetter.GetMany,
+// it has no effect if the Getter of the group is not a BatchGetter.
+func WithBatching(cfg BatchConfig) GroupOption {
+	return func(g *Group) {
+		getter, ok := g.getter.(BatchGetter)
+		if !ok {
+			return
+		}
+		if cfg.Window <= 0 {
+			cfg.Window = defaultBatchWindow
+		}
+		if cfg.MaxSize <= 0 {
+			cfg.MaxSize = defaultMaxBatchSize
+		}
+		g.batcher = &batcher{cfg: cfg, getter: getter}
+	}
+}
+
+// batchCall 为一批中单个 key 的加载，ctx 为该 key 的加载使用的 ctx
+type batchCall struct {
+	ctx    context.Context
+	key    string
+	value  []byte
+	expire time.Time
+	err    error
+	done   chan struct{}
+}
+
+// pendingBatch 为正在累积的一批
+type pendingBatch struct {
+	calls []*batchCall
+	timer *time.Timer
+}
+
+// batcher 将并发的本地加载合并为批量加载
+type batcher struct {
+	cfg    BatchConfig
+	getter BatchGetter
+
+	mu      sync.Mutex
+	current *pendingBatch
+}
+
+// get 将 key 加入当前批次并等待结果，ctx 取消时直接返回，批量加载依旧会完成
+func (b *batcher) get(ctx context.Context, g *Group, key string) ([]byte, time.Time, error) {
+	c := &batchCall{ctx: ctx, key: key, done: make(chan struct{})}
+
+	b.mu.Lock()
+	pb := b.current
+	if pb == nil {
+		pb = &pendingBatch{}
+		b.current = pb
+		pb.timer = time.AfterFunc(b.cfg.Window, func() {
+			if b.take(pb) {
+				g.flushBatch(b.getter, pb.calls)
+			}
+		})
+	}
+	pb.calls = append(pb.calls, c)
+	full := len(pb.calls) >= b.cfg.MaxSize
+	if full {
+		pb.timer.Stop()
+		b.current = nil
+	}
+	b.mu.Unlock()
+	if full {
+		go g.flushBatch(b.getter, pb.calls)
+	}
+
+	select {
+	case <-c.done:
+		return c.value, c.expire, c.err
+	case <-ctx.Done():
+		return nil, time.Time{}, ctx.Err()
+	}
+}
+
+// take 在 pb 仍是当前批次时将其取出，已因达到 MaxSize 被取出时返回 false
+func (b *batcher) take(pb *pendingBatch) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.current != pb {
+		return false
+	}
+	b.current = nil
+	return true
+}
+
+// flushBatch 通过一次 GetMany 调用加载一批 key，并将结果分发给等待的调用方
+// 一批包含多个加载，只有所有加载都被取消后 GetMany 的 ctx 才被取消
+func (g *Group) flushBatch(getter BatchGetter, calls []*batchCall) {
+	ctx, cancel := batchContext(calls)
+	defer cancel()
+	keys := make([]string, len(calls))
+	for i, c := range calls {
+		keys[i] = c.key
+	}
+	g.stats.LocalBatches.Add(1)
+	var (
+		values  [][]byte
+		expires []time.Time
+		errs    []error
+	)
+	if eg, ok := getter.(ExpiringBatchGetter); ok {
+		values, expires, errs = eg.GetManyWithExpire(ctx, keys)
+	} else {
+		values, errs = getter.GetMany(ctx, keys)
+	}
+	for i, c := range calls {
+		c.value, c.err = batchResult(values, errs, i)
+		if c.err == nil && i < len(expires) {
+			c.expire = expires[i]
+		}
+		close(c.done)
+	}
+}
+
+// batchContext 返回在 calls 的 ctx 全部取消后被取消的 ctx
+func batchContext(calls []*batchCall) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for _, c := range calls {
+			select {
+			case <-c.ctx.Done():
+			case <-ctx.Done():
+				return
+			}
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,127 @@
+package groupcache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingBatchGetter 记录每次 GetMany 调用的 key，key 为 "missing" 时返回错误
+type recordingBatchGetter struct {
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (r *recordingBatchGetter) getter() BatchGetter {
+	return BatchGetterFunc(func(ctx context.Context, keys []string) ([][]byte, []error) {
+		r.mu.Lock()
+		r.batches = append(r.batches, keys)
+		r.mu.Unlock()
+		values := make([][]byte, len(keys))
+		errs := make([]error, len(keys))
+		for i, key := range keys {
+			if key == "missing" {
+				errs[i] = fmt.Errorf("%s not exist", key)
+				continue
+			}
+			values[i] = []byte("v-" + key)
+		}
+		return values, errs
+	})
+}
+
+func (r *recordingBatchGetter) snapshot() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]string(nil), r.batches...)
+}
+
+func TestBatchingCoalescesMisses(t *testing.T) {
+	r := &recordingBatchGetter{}
+	g := NewGroup("batching", 2<<10, r.getter(), WithBatching(BatchConfig{Window: 50 * time.Millisecond}))
+
+	// 每个 key 有两个并发请求，singleflight 去重后合并为一次 GetMany
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			if v, err := g.Get(key); err != nil || v.String() != "v-"+key {
+				t.Errorf("Get(%s) = %q, %v", key, v.String(), err)
+			}
+		}(fmt.Sprintf("k%d", i%10))
+	}
+	wg.Wait()
+
+	batches := r.snapshot()
+	if len(batches) != 1 {
+		t.Fatalf("expect 1 batch, got %v", batches)
+	}
+	keys := append([]string(nil), batches[0]...)
+	sort.Strings(keys)
+	if len(keys) != 10 || keys[0] != "k0" || keys[9] != "k9" {
+		t.Fatalf("unexpected keys in batch %v", keys)
+	}
+	if s := g.Stats(); s.LocalBatches != 1 || s.LocalLoads != 10 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestBatchingMaxSize(t *testing.T) {
+	r := &recordingBatchGetter{}
+	// 窗口足够长，只有达到 MaxSize 才会加载
+	g := NewGroup("batching-max-size", 2<<10, r.getter(), WithBatching(BatchConfig{Window: time.Hour, MaxSize: 3}))
+
+	errs := make(chan error, 3)
+	for _, key := range []string{"Tom", "Jack", "missing"} {
+		go func(key string) {
+			_, err := g.Get(key)
+			errs <- err
+		}(key)
+	}
+	failed := 0
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				failed++
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("full batch should be loaded without waiting for the window")
+		}
+	}
+	if failed != 1 {
+		t.Fatalf("only the missing key should fail, got %d errors", failed)
+	}
+	if batches := r.snapshot(); len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("expect 1 batch of 3 keys, got %v", batches)
+	}
+
+	// GetMany 同样按 MaxSize 拆分
+	g.GetMany([]string{"a", "b", "c", "d", "e"})
+	if batches := r.snapshot(); len(batches) != 3 || len(batches[1]) != 3 || len(batches[2]) != 2 {
+		t.Fatalf("GetMany should be split by MaxSize, got %v", batches)
+	}
+}
+
+func TestBatchingContext(t *testing.T) {
+	r := &recordingBatchGetter{}
+	g := NewGroup("batching-context", 2<<10, r.getter(), WithBatching(BatchConfig{Window: time.Hour}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := g.GetContext(ctx, "Tom"); err != context.DeadlineExceeded {
+		t.Fatalf("waiting caller should return on ctx done, got %v", err)
+	}
+
+	// Getter 不是 BatchGetter 时 WithBatching 不生效
+	plain := NewGroup("batching-plain", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithBatching(BatchConfig{}))
+	if plain.batcher != nil {
+		t.Fatalf("batching should be disabled for plain Getter")
+	}
+}
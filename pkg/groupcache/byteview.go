@@ -1,9 +1,13 @@
 package groupcache
 
+import "time"
+
 // ByteView holds an immutable view of bytes.
 // 提供字节形式的存储，可以兼容多种数据源（文本、图片等）
+// e 为过期时间，零值表示永不过期
 type ByteView struct {
 	b []byte
+	e time.Time
 }
 
 // Len implements interface lru.Value.Len()
@@ -11,6 +15,11 @@ func (v ByteView) Len() int {
 	return len(v.b)
 }
 
+// Expire returns the expiry time of the view, zero means never expire.
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
 // ByteSlice returns a copy of the data as a byte slice, incase it will be modified.
 func (v ByteView) ByteSlice() []byte {
 	return cloneBytes(v.b)
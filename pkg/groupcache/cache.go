@@ -2,37 +2,109 @@ package groupcache
 
 import (
 	"sync"
+	"time"
 
 	"github.com/fusidic/FuCache/pkg/lru"
 )
 
+// cache 是 lru.Cache 的并发安全封装
+// nget, nhit, nevict, nexpire 为统计计数，与 lru 一样受 mu 保护
+// nevict 只统计因容量不足被淘汰的节点，nexpire 统计过期被删除的节点，显式删除不计入
 type cache struct {
 	mu         sync.Mutex
 	lru        *lru.Cache
 	cacheBytes int64 // 缓存容量
+	nget       int64
+	nhit       int64
+	nevict     int64
+	nexpire    int64
+	// removing 在显式删除期间为 true，使 OnEvicted 不计数
+	removing bool
 }
 
 func (c *cache) add(key string, value ByteView) {
+	c.addWithExpire(key, value, value.Expire())
+}
+
+// addWithExpire 与 add 相同，但使用 expire 而不是值自身的过期时间
+func (c *cache) addWithExpire(key string, value ByteView, expire time.Time) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	// 延迟创建，当第一次调用add方法的时候再创建LRU
 	if c.lru == nil {
-		c.lru = lru.NewLRU(c.cacheBytes, nil)
+		c.lru = lru.NewLRU(c.cacheBytes, c.onEvicted)
+	}
+	c.lru.AddWithExpire(key, value, expire)
+}
+
+// onEvicted 区分节点被删除的原因，调用时已持有 mu
+func (c *cache) onEvicted(key string, value lru.Value) {
+	switch e := value.(ByteView).Expire(); {
+	case c.removing:
+	case !e.IsZero() && !time.Now().Before(e):
+		c.nexpire++
+	default:
+		c.nevict++
 	}
-	c.lru.Add(key, value)
 }
 
 func (c *cache) get(key string) (value ByteView, ok bool) {
 	// 加上了并发读写的锁
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.nget++
 	if c.lru == nil {
 		return
 	}
-	// 全都是封装
+	// 全都是封装，过期的节点由 lru 视为未命中
 	if v, ok := c.lru.Get(key); ok {
+		c.nhit++
 		return v.(ByteView), ok
 	}
 	return
 }
+
+func (c *cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return
+	}
+	c.removing = true
+	c.lru.Remove(key)
+	c.removing = false
+}
+
+func (c *cache) removeOldest() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru != nil {
+		c.lru.RemoveOldest()
+	}
+}
+
+func (c *cache) bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	return c.lru.Bytes()
+}
+
+func (c *cache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := CacheStats{
+		Gets:        c.nget,
+		Hits:        c.nhit,
+		Evictions:   c.nevict,
+		Expirations: c.nexpire,
+	}
+	if c.lru != nil {
+		s.Bytes = c.lru.Bytes()
+		s.Items = int64(c.lru.Len())
+	}
+	return s
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"sync"
 	"testing"
+	"time"
 )
 
 func Test_get(t *testing.T) {
@@ -18,3 +19,18 @@ func Test_get(t *testing.T) {
 	log.Printf("value: '%v' ok: %v r: %v\n", v, ok, r)
 	fmt.Printf("%v %v\n", v, ok)
 }
+
+func TestCacheEvictionStats(t *testing.T) {
+	c := &cache{cacheBytes: 10}
+	c.add("a", ByteView{b: []byte("1")})
+	c.add("b", ByteView{b: []byte("2"), e: time.Now().Add(-time.Second)})
+	c.add("c", ByteView{b: []byte("3")})
+	c.remove("c")
+	// 超出容量淘汰 a
+	c.add("d", ByteView{b: []byte("123456789")})
+
+	s := c.stats()
+	if s.Evictions != 1 || s.Expirations != 1 {
+		t.Fatalf("expect 1 eviction and 1 expiration, got %+v", s)
+	}
+}
@@ -1,9 +1,12 @@
 package groupcache
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math/rand"
 	"sync"
+	"time"
 
 	"github.com/fusidic/FuCache/pkg/singleflight"
 	"github.com/fusidic/FuCache/proto/cachepb"
@@ -23,37 +26,126 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// ExpiringGetter is a Getter which can also return the expiry time of the data.
+// Group 会优先使用 GetWithExpire，零值的过期时间表示永不过期
+type ExpiringGetter interface {
+	Getter
+	GetWithExpire(key string) ([]byte, time.Time, error)
+}
+
+// ExpiringGetterFunc implements ExpiringGetter.
+type ExpiringGetterFunc func(key string) ([]byte, time.Time, error)
+
+// Get implements Getter.Get()
+func (f ExpiringGetterFunc) Get(key string) ([]byte, error) {
+	bytes, _, err := f(key)
+	return bytes, err
+}
+
+// GetWithExpire implements ExpiringGetter.GetWithExpire()
+func (f ExpiringGetterFunc) GetWithExpire(key string) ([]byte, time.Time, error) {
+	return f(key)
+}
+
+// ContextGetter is a Getter which receives the context of the caller,
+// so that cancellation and deadlines propagate to the data source.
+// Group 会优先使用 GetContext
+type ContextGetter interface {
+	Getter
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// ContextExpiringGetter is a Getter which receives the context of the caller
+// and returns the expiry time of the data, Group 会优先使用 GetContextWithExpire
+type ContextExpiringGetter interface {
+	Getter
+	GetContextWithExpire(ctx context.Context, key string) ([]byte, time.Time, error)
+}
+
+// ContextExpiringGetterFunc implements ContextExpiringGetter.
+type ContextExpiringGetterFunc func(ctx context.Context, key string) ([]byte, time.Time, error)
+
+// Get implements Getter.Get()
+func (f ContextExpiringGetterFunc) Get(key string) ([]byte, error) {
+	bytes, _, err := f(context.Background(), key)
+	return bytes, err
+}
+
+// GetContextWithExpire implements ContextExpiringGetter.GetContextWithExpire()
+func (f ContextExpiringGetterFunc) GetContextWithExpire(ctx context.Context, key string) ([]byte, time.Time, error) {
+	return f(ctx, key)
+}
+
+// ContextGetterFunc implements ContextGetter.
+type ContextGetterFunc func(ctx context.Context, key string) ([]byte, error)
+
+// Get implements Getter.Get()
+func (f ContextGetterFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+// GetContext implements ContextGetter.GetContext()
+func (f ContextGetterFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
 // Group is a cache namespace and associate data in all nodes.
 // Group 是缓存的命名空间，每个 Group 拥有唯一 name，如可以创建三个 Group：
 //   学生成绩 scores，学生信息 info，学生课程 courses
 // getter 为当未命中时获取源数据的 callback
-// mainCache 并发缓存 (cache.go)
+// mainCache 并发缓存 (cache.go)，存放本节点拥有的 key
+// hotCache 存放从远程节点获取的热点 key 的副本，避免热点 key 每次都要跨网络访问
+// cacheBytes 为 mainCache 与 hotCache 共享的总容量
+// stats 为统计计数，通过 Stats() 获取
 type Group struct {
-	name      string
-	getter    Getter
-	mainCache cache
-	peers     PeerPicker
+	name       string
+	getter     Getter
+	cacheBytes int64
+	mainCache  cache
+	hotCache   cache
+	peers      PeerPicker
 	// use singleflight.Group to make sure that each key is only fetched once
 	loader *singleflight.Group
+	stats  groupStats
+	// 不为 nil 时启用对冲与重试
+	hedge *hedger
+	// 不为 nil 时将并发的本地加载合并为批量加载
+	batcher *batcher
+	// 不为 nil 时在值即将过期时后台刷新
+	refresh *refresher
+	// 不为 nil 时在加载失败时返回最后一个有效值
+	stale *staleCache
 }
 
+const (
+	// hotCache 的容量上限为 mainCache 的 1/hotCacheRatio
+	hotCacheRatio = 8
+	// 从远程节点获取的值有 1/hotCachePopulateChance 的概率存入 hotCache
+	hotCachePopulateChance = 10
+)
+
 var (
 	mu     sync.RWMutex
 	groups = make(map[string]*Group)
 )
 
 // NewGroup create a new instance of Group
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+func NewGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("nil Getter")
 	}
 	mu.Lock()
 	defer mu.Unlock()
 	g := &Group{
-		name:      name,
-		getter:    getter,
-		mainCache: cache{cacheBytes: cacheBytes},
-		loader:    &singleflight.Group{},
+		name:       name,
+		getter:     getter,
+		cacheBytes: cacheBytes,
+		mainCache:  cache{cacheBytes: cacheBytes},
+		hotCache:   cache{cacheBytes: cacheBytes / hotCacheRatio},
+		loader:     &singleflight.Group{},
+	}
+	for _, opt := range opts {
+		opt(g)
 	}
 	groups[name] = g
 	return g
@@ -68,19 +160,75 @@ func GetGroup(name string) *Group {
 	return g
 }
 
+// GetGroups returns all groups previously created with NewGroup.
+func GetGroups() map[string]*Group {
+	mu.RLock()
+	defer mu.RUnlock()
+	gs := make(map[string]*Group, len(groups))
+	for name, g := range groups {
+		gs[name] = g
+	}
+	return gs
+}
+
+// Name returns the name of the group.
+func (g *Group) Name() string {
+	return g.name
+}
+
 // 核心方法实现
 
 // Get value for a key from cache
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext value for a key from cache, ctx is passed to peers and the Getter.
+// 同一个 key 的并发请求只会加载一次，加载使用首个请求 ctx 的值，但不带任何请求的截止时间；
+// 调用方取消时直接返回，等待该加载的请求全部取消后，加载才会被取消
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("Require a key")
 	}
 
-	if v, ok := g.mainCache.get(key); ok {
+	g.stats.Gets.Add(1)
+	if v, ok := g.lookupCache(key); ok {
+		g.stats.CacheHits.Add(1)
 		log.Printf("[GroupCache] hit")
+		g.maybeRefresh(key, v)
 		return v, nil
 	}
-	return g.load(key)
+	return g.load(ctx, key)
+}
+
+// Remove the key from the cache of its owner and of this node.
+// 先通知拥有该 key 的远程节点删除，再删除本地缓存；
+// 即使远程节点删除失败，本地缓存依旧会被删除
+// 注意其他节点 hotCache 中的副本不会被删除，只能等待其淘汰或过期
+func (g *Group) Remove(key string) error {
+	return g.RemoveContext(context.Background(), key)
+}
+
+// RemoveContext removes the key like Remove, ctx is passed to the owner peer.
+func (g *Group) RemoveContext(ctx context.Context, key string) error {
+	if key == "" {
+		return fmt.Errorf("Require a key")
+	}
+
+	// 备用节点在主节点故障时可能缓存了该 key，也需要删除；任一节点删除失败都返回错误
+	var err error
+	for _, peer := range g.replicaPeers(ctx, key) {
+		if perr := g.removeFromPeer(ctx, peer, key); perr != nil {
+			log.Println("[GroupCache] Failed to remove from peer", perr)
+			err = perr
+		}
+	}
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	if g.stale != nil {
+		g.stale.cache.remove(key)
+	}
+	return err
 }
 
 // RegisterPeers registers a PeerPicker for choosing remote peer.
@@ -93,36 +241,108 @@ func (g *Group) RegisterPeers(peers PeerPicker) {
 
 // load value if not exist
 // 单机环境下，会从数据源中回调；分布式环境下，会从其他节点中回调
-func (g *Group) load(key string) (value ByteView, err error) {
-	viewi, err := g.loader.Do(key, func() (interface{}, error) {
-		if g.peers != nil {
-			// 根据哈希，选择远程节点
-			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err = g.getFromPeer(peer, key); err == nil {
-					return value, nil
-				}
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
+	return g.loadWith(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return g.doLoad(ctx, key)
+	}, nil)
+}
+
+// loadWith 在 singleflight 中调用 fn 加载 key，joined 不为 nil 时在加入进行中的加载时被调用
+// 调用方取消时直接返回；fn 的 ctx 不带任何调用方的截止时间，所有等待的调用方都离开后才被取消
+func (g *Group) loadWith(ctx context.Context, key string, fn func(context.Context) (interface{}, error), joined func()) (ByteView, error) {
+	// 调用方已经取消或超时，不再发起加载
+	if err := ctx.Err(); err != nil {
+		return ByteView{}, err
+	}
+	g.stats.Loads.Add(1)
+	viewi, err := g.loader.DoContext(ctx, key, fn, joined)
+	if err != nil {
+		return ByteView{}, err
+	}
+	return viewi.(ByteView), nil
+}
+
+// doLoad 在 singleflight 中执行一次加载
+func (g *Group) doLoad(ctx context.Context, key string) (interface{}, error) {
+	g.stats.LoadsDeduped.Add(1)
+	// 根据哈希，选择远程节点，主节点失败时依次尝试备用节点，最后从本地加载
+	var attempts []attempt
+	for _, peer := range g.routePeers(ctx, key) {
+		peer := peer
+		attempts = append(attempts, func(ctx context.Context) (ByteView, error) {
+			value, err := g.getFromPeerWithRetry(ctx, peer, key)
+			if err != nil {
+				g.stats.PeerErrors.Add(1)
 				log.Println("[GroupCache] Failed to get from peer", err)
+				return ByteView{}, err
 			}
+			g.stats.PeerLoads.Add(1)
+			return value, nil
+		})
+	}
+	attempts = append(attempts, func(ctx context.Context) (ByteView, error) {
+		// 加载已经超时或被取消，不再回源加载
+		if err := ctx.Err(); err != nil {
+			return ByteView{}, err
 		}
-
-		// 此处逻辑感觉有些不对
-		return g.getLocally(key)
+		value, err := g.getLocally(ctx, key)
+		if err != nil {
+			g.stats.LocalLoadErrs.Add(1)
+			return ByteView{}, err
+		}
+		g.stats.LocalLoads.Add(1)
+		return value, nil
 	})
+	value, err := g.race(ctx, attempts)
+	if err != nil {
+		if v, ok := g.staleOnError(key, err); ok {
+			return v, nil
+		}
+	}
+	return value, err
+}
 
-	if err == nil {
-		return viewi.(ByteView), nil
+// routePeers 返回处理 ctx 中的请求时应尝试的远程节点，来自其他节点的请求只在本地处理
+func (g *Group) routePeers(ctx context.Context, key string) []PeerGetter {
+	if isFromPeer(ctx) {
+		return nil
 	}
-	return
+	return g.pickPeers(key)
 }
 
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+// replicaPeers 返回删除 key 时需要通知的远程节点，来自其他节点的请求只在本地处理
+func (g *Group) replicaPeers(ctx context.Context, key string) []PeerGetter {
+	if isFromPeer(ctx) {
+		return nil
+	}
+	if rp, ok := g.peers.(ReplicaPicker); ok {
+		return rp.PickReplicas(key)
+	}
+	return g.pickPeers(key)
+}
+
+// pickPeers 返回 key 对应的远程节点列表，为空表示应从本地加载
+func (g *Group) pickPeers(key string) []PeerGetter {
+	if g.peers == nil {
+		return nil
+	}
+	if lp, ok := g.peers.(PeerListPicker); ok {
+		return lp.PickPeers(key)
+	}
+	if peer, ok := g.peers.PickPeer(key); ok {
+		return []PeerGetter{peer}
+	}
+	return nil
+}
+
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
 	req := &cachepb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 
 	res := &cachepb.Response{}
-	err := peer.Get(req, res)
+	err := peer.Get(ctx, req, res)
 	if err != nil {
 		// log.Printf("[Server] Not exist, loading ...")
 		// g.getLocally(key)
@@ -130,21 +350,81 @@ func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
 		// return ByteView{b: bytes}, nil
 		return ByteView{}, err
 	}
-	return ByteView{b: res.Value}, nil
+	value := ByteView{b: res.Value, e: unixNano(res.Expire)}
+	// 热点 key 被访问得越频繁，越有可能被存入 hotCache
+	if rand.Intn(hotCachePopulateChance) == 0 {
+		g.populateCache(key, value, &g.hotCache)
+	}
+	return value, nil
+}
+
+func (g *Group) removeFromPeer(ctx context.Context, peer PeerGetter, key string) error {
+	req := &cachepb.Request{
+		Group: g.name,
+		Key:   key,
+	}
+	return peer.Remove(ctx, req)
 }
 
-func (g *Group) getLocally(key string) (ByteView, error) {
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
 	// 调用 getter.Get 获取数据源
-	bytes, err := g.getter.Get(key)
+	var (
+		bytes  []byte
+		expire time.Time
+		err    error
+	)
+	if g.batcher != nil {
+		// 与其他 key 合并为一次 BatchGetter.GetMany 调用
+		bytes, expire, err = g.batcher.get(ctx, g, key)
+	} else {
+		switch getter := g.getter.(type) {
+		case ContextExpiringGetter:
+			bytes, expire, err = getter.GetContextWithExpire(ctx, key)
+		case ContextGetter:
+			bytes, err = getter.GetContext(ctx, key)
+		case ExpiringGetter:
+			bytes, expire, err = getter.GetWithExpire(key)
+		default:
+			bytes, err = getter.Get(key)
+		}
+	}
 	if err != nil {
 		return ByteView{}, err
 	}
-	value := ByteView{b: cloneBytes(bytes)}
+	value := ByteView{b: cloneBytes(bytes), e: expire}
 	// 添加到缓存中
-	g.populateCache(key, value)
+	g.populateCache(key, value, &g.mainCache)
 	return value, nil
 }
 
-func (g *Group) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+func (g *Group) lookupCache(key string) (value ByteView, ok bool) {
+	if value, ok = g.mainCache.get(key); ok {
+		return
+	}
+	value, ok = g.hotCache.get(key)
+	return
+}
+
+// populateCache 将值加入指定的缓存，并在两个缓存总容量超出 cacheBytes 时进行淘汰
+// hotCache 超过 mainCache 的 1/hotCacheRatio 时优先淘汰 hotCache，否则淘汰 mainCache
+func (g *Group) populateCache(key string, value ByteView, cache *cache) {
+	cache.add(key, value)
+	if g.stale != nil && cache == &g.mainCache {
+		g.stale.add(key, value)
+	}
+	if g.cacheBytes == 0 {
+		return
+	}
+	for {
+		mainBytes := g.mainCache.bytes()
+		hotBytes := g.hotCache.bytes()
+		if mainBytes+hotBytes <= g.cacheBytes {
+			return
+		}
+		victim := &g.mainCache
+		if hotBytes > mainBytes/hotCacheRatio {
+			victim = &g.hotCache
+		}
+		victim.removeOldest()
+	}
 }
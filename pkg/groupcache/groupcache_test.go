@@ -1,10 +1,17 @@
 package groupcache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/fusidic/FuCache/proto/cachepb"
 )
 
 // simulate database
@@ -56,3 +63,375 @@ func TestGet(t *testing.T) {
 		log.Printf("%s", err)
 	}
 }
+
+func TestGetWithExpire(t *testing.T) {
+	loadCounts := make(map[string]int)
+	ttl := map[string]time.Duration{
+		"Tom":  time.Hour,
+		"Jack": -time.Second,
+	}
+	mem := NewGroup("expire", 2<<10, ExpiringGetterFunc(
+		func(key string) ([]byte, time.Time, error) {
+			loadCounts[key]++
+			return []byte(db[key]), time.Now().Add(ttl[key]), nil
+		}))
+	for i := 0; i < 2; i++ {
+		for k := range ttl {
+			if view, err := mem.Get(k); err != nil || view.String() != db[k] {
+				t.Fatalf("failed to get value of %s", k)
+			}
+		}
+	}
+	if loadCounts["Tom"] != 1 {
+		t.Fatalf("Tom should be cached, loaded %d times", loadCounts["Tom"])
+	}
+	if loadCounts["Jack"] != 2 {
+		t.Fatalf("expired Jack should be reloaded, loaded %d times", loadCounts["Jack"])
+	}
+}
+
+// fakePeer 记录收到的请求，用于模拟远程节点
+type fakePeer struct {
+	fail    bool
+	gets    int
+	removed []string
+	// get 不为 nil 时代替 db 返回结果，用于模拟缓慢或出错的节点
+	get func(ctx context.Context, key string) ([]byte, error)
+}
+
+func (p *fakePeer) Get(ctx context.Context, in *cachepb.Request, out *cachepb.Response) error {
+	if p.get != nil {
+		value, err := p.get(ctx, in.GetKey())
+		out.Value = value
+		return err
+	}
+	if p.fail {
+		return fmt.Errorf("peer unavailable")
+	}
+	p.gets++
+	out.Value = []byte(db[in.GetKey()])
+	return nil
+}
+
+func (p *fakePeer) Remove(ctx context.Context, in *cachepb.Request) error {
+	p.removed = append(p.removed, in.GetKey())
+	return nil
+}
+
+type fakePicker struct {
+	peer *fakePeer
+}
+
+func (p *fakePicker) PickPeer(key string) (PeerGetter, bool) {
+	return p.peer, true
+}
+
+func TestRemove(t *testing.T) {
+	loadCounts := make(map[string]int)
+	mem := NewGroup("remove", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loadCounts[key]++
+			return []byte(db[key]), nil
+		}))
+	peer := &fakePeer{fail: true}
+	mem.RegisterPeers(&fakePicker{peer: peer})
+
+	mem.Get("Tom")
+	if err := mem.Remove("Tom"); err != nil {
+		t.Fatalf("failed to remove Tom: %v", err)
+	}
+	mem.Get("Tom")
+	if loadCounts["Tom"] != 2 {
+		t.Fatalf("removed Tom should be reloaded, loaded %d times", loadCounts["Tom"])
+	}
+	if !reflect.DeepEqual(peer.removed, []string{"Tom"}) {
+		t.Fatalf("remove should be forwarded to owner, got %v", peer.removed)
+	}
+}
+
+func TestHotCache(t *testing.T) {
+	mem := NewGroup("hot", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s should be fetched from peer", key)
+		}))
+	peer := &fakePeer{}
+	mem.RegisterPeers(&fakePicker{peer: peer})
+
+	// 从远程节点获取的值按概率存入 hotCache，多次访问后应当命中
+	for i := 0; i < 1000; i++ {
+		if view, err := mem.Get("Tom"); err != nil || view.String() != db["Tom"] {
+			t.Fatalf("failed to get value of Tom from peer")
+		}
+	}
+	if _, ok := mem.hotCache.get("Tom"); !ok {
+		t.Fatalf("Tom should be populated into hot cache")
+	}
+	if peer.gets == 1000 {
+		t.Fatalf("hot cache never hit")
+	}
+	if _, ok := mem.mainCache.get("Tom"); ok {
+		t.Fatalf("value owned by peer should not be in main cache")
+	}
+}
+
+func TestPopulateCacheEviction(t *testing.T) {
+	mem := NewGroup("evict", 100, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	value := ByteView{b: make([]byte, 8)}
+	for i := 0; i < 20; i++ {
+		mem.populateCache(fmt.Sprintf("main%02d", i), value, &mem.mainCache)
+		mem.populateCache(fmt.Sprintf("hot%02d", i), value, &mem.hotCache)
+	}
+	mainBytes, hotBytes := mem.mainCache.bytes(), mem.hotCache.bytes()
+	if mainBytes+hotBytes > 100 {
+		t.Fatalf("caches use %d bytes, exceed limit 100", mainBytes+hotBytes)
+	}
+	if hotBytes > 100/hotCacheRatio {
+		t.Fatalf("hot cache uses %d bytes, exceed its share", hotBytes)
+	}
+}
+
+type ctxKey struct{}
+
+func TestGetContext(t *testing.T) {
+	loads := 0
+	mem := NewGroup("context", 2<<10, ContextGetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			loads++
+			if ctx.Value(ctxKey{}) != "api" {
+				t.Errorf("context is not passed to getter")
+			}
+			return []byte(db[key]), nil
+		}))
+	mem.RegisterPeers(&fakePicker{peer: &fakePeer{fail: true}})
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "api")
+	if view, err := mem.GetContext(ctx, "Tom"); err != nil || view.String() != db["Tom"] {
+		t.Fatalf("failed to get value of Tom")
+	}
+
+	// 已取消的请求不应回源加载
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := mem.GetContext(ctx, "Jack"); err != context.Canceled {
+		t.Fatalf("canceled get should return context.Canceled, got %v", err)
+	}
+	if loads != 1 {
+		t.Fatalf("canceled get should not load from getter, loaded %d times", loads)
+	}
+}
+
+func TestFromPeer(t *testing.T) {
+	loads := 0
+	peer := &fakePeer{}
+	mem := NewGroup("from-peer", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(db[key]), nil
+		}))
+	mem.RegisterPeers(&fakePicker{peer: peer})
+
+	// 来自其他节点的请求只在本地加载与删除，不再转发
+	ctx := FromPeer(context.Background())
+	if view, err := mem.GetContext(ctx, "Tom"); err != nil || view.String() != db["Tom"] {
+		t.Fatalf("failed to get value of Tom")
+	}
+	if r := mem.GetManyContext(ctx, []string{"Jack"}); r[0].Err != nil {
+		t.Fatalf("failed to get many: %v", r[0].Err)
+	}
+	if err := mem.RemoveContext(ctx, "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if peer.gets != 0 || len(peer.removed) != 0 || loads != 2 {
+		t.Fatalf("request from peer should be handled locally, peer gets %d, removed %v, loads %d", peer.gets, peer.removed, loads)
+	}
+
+	mem.Get("fusidic")
+	if peer.gets != 1 {
+		t.Fatalf("local request should be sent to the peer")
+	}
+}
+
+func TestGetContextWithExpire(t *testing.T) {
+	expire := time.Now().Add(time.Hour)
+	mem := NewGroup("context-expire", 2<<10, ContextExpiringGetterFunc(
+		func(ctx context.Context, key string) ([]byte, time.Time, error) {
+			if ctx.Value(ctxKey{}) != "api" {
+				t.Errorf("context is not passed to getter")
+			}
+			return []byte(db[key]), expire, nil
+		}))
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "api")
+	view, err := mem.GetContext(ctx, "Tom")
+	if err != nil || view.String() != db["Tom"] {
+		t.Fatalf("failed to get value of Tom")
+	}
+	if !view.Expire().Equal(expire) {
+		t.Fatalf("expire %v, expect %v", view.Expire(), expire)
+	}
+}
+
+func TestLoadOutlivesCanceledCaller(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	var hasDeadline, canceled int32
+	mem := NewGroup("context-detach", 2<<10, ContextGetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			if _, ok := ctx.Deadline(); ok {
+				atomic.StoreInt32(&hasDeadline, 1)
+			}
+			once.Do(func() { close(started) })
+			<-release
+			if err := ctx.Err(); err != nil {
+				atomic.AddInt32(&canceled, 1)
+				return nil, err
+			}
+			return []byte(db[key]), nil
+		}))
+
+	// 首个请求的截止时间很短，第二个请求没有截止时间
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	first := make(chan error, 1)
+	go func() {
+		_, err := mem.GetContext(ctx, "Tom")
+		first <- err
+	}()
+	<-started
+	joined := make(chan struct{})
+	second := make(chan error, 1)
+	go func() {
+		view, err := mem.loadWith(context.Background(), "Tom", func(ctx context.Context) (interface{}, error) {
+			return nil, errors.New("should join the running load")
+		}, func() { close(joined) })
+		if err == nil && view.String() != db["Tom"] {
+			err = fmt.Errorf("got %q", view.String())
+		}
+		second <- err
+	}()
+	<-joined
+
+	// 首个请求超时后立即返回，加载不受影响，其他等待的请求依旧得到结果
+	if err := <-first; err != context.DeadlineExceeded {
+		t.Fatalf("first caller should return context.DeadlineExceeded, got %v", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Fatalf("waiting caller failed: %v", err)
+	}
+	if atomic.LoadInt32(&canceled) != 0 || atomic.LoadInt32(&hasDeadline) != 0 {
+		t.Fatalf("shared load should neither be canceled nor carry the deadline of the first caller")
+	}
+}
+
+func TestLoadCanceledWithLastCaller(t *testing.T) {
+	started, canceled := make(chan struct{}), make(chan struct{})
+	mem := NewGroup("context-cancel", 2<<10, ContextGetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			close(started)
+			<-ctx.Done()
+			close(canceled)
+			return nil, ctx.Err()
+		}))
+
+	// 唯一的调用方断开后，加载随之取消
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := mem.GetContext(ctx, "Tom")
+		done <- err
+	}()
+	<-started
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("caller should return context.Canceled, got %v", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatalf("load should be canceled after its only caller left")
+	}
+}
+
+func TestStats(t *testing.T) {
+	mem := NewGroup("stats", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+	mem.Get("Tom")
+	mem.Get("Tom")
+	mem.Get("unknown")
+	mem.RecordServerRequest()
+
+	stats := mem.Stats()
+	expect := Stats{
+		Gets:           3,
+		CacheHits:      1,
+		Loads:          2,
+		LoadsDeduped:   2,
+		LocalLoads:     1,
+		LocalLoadErrs:  1,
+		ServerRequests: 1,
+		MainCache: CacheStats{
+			Bytes: int64(len("Tom") + len(db["Tom"])),
+			Items: 1,
+			Gets:  3,
+			Hits:  1,
+		},
+		HotCache: CacheStats{Gets: 2},
+	}
+	if !reflect.DeepEqual(stats, expect) {
+		t.Fatalf("stats %+v, expect %+v", stats, expect)
+	}
+}
+
+type fakeListPicker struct {
+	peers []*fakePeer
+}
+
+func (p *fakeListPicker) PickPeer(key string) (PeerGetter, bool) {
+	return p.peers[0], true
+}
+
+func (p *fakeListPicker) PickPeers(key string) []PeerGetter {
+	peers := make([]PeerGetter, 0, len(p.peers))
+	for _, peer := range p.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+func TestFallbackPeers(t *testing.T) {
+	loads := 0
+	mem := NewGroup("fallback", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(db[key]), nil
+		}))
+	primary, secondary := &fakePeer{fail: true}, &fakePeer{}
+	mem.RegisterPeers(&fakeListPicker{peers: []*fakePeer{primary, secondary}})
+
+	if view, err := mem.Get("Tom"); err != nil || view.String() != db["Tom"] {
+		t.Fatalf("failed to get value of Tom")
+	}
+	if secondary.gets != 1 || loads != 0 {
+		t.Fatalf("should fall back to secondary before loading locally, secondary gets %d, loads %d", secondary.gets, loads)
+	}
+
+	secondary.fail = true
+	mem.Get("Jack")
+	if loads != 1 {
+		t.Fatalf("should load locally after all peers failed, loads %d", loads)
+	}
+
+	mem.Remove("Tom")
+	if len(primary.removed) != 1 || len(secondary.removed) != 1 {
+		t.Fatalf("remove should be forwarded to all peers")
+	}
+}
@@ -0,0 +1,246 @@
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// latencySamples 为估算延迟分位数时保留的最近样本数
+	latencySamples = 256
+	// minLatencySamples 为样本数不足时使用 HedgeConfig.Delay
+	minLatencySamples = 20
+	// defaultRetryBackoff 为重试前等待的初始时间，每次重试翻倍
+	defaultRetryBackoff = 10 * time.Millisecond
+	// defaultBudgetTokens 为重试预算的初始值与上限
+	defaultBudgetTokens = 10
+	// defaultBudgetRatio 为 BudgetRatio 未设置时每次加载补充的预算
+	defaultBudgetRatio = 0.1
+)
+
+// HedgeConfig configures hedged and retried requests to peers.
+// 当主节点在 Percentile 分位的延迟内仍未返回时，向下一个备用节点（没有备用节点时从本地加载）
+// 发出对冲请求，取先返回的结果；对临时性错误进行重试。
+// 对冲与重试都会消耗预算，每次加载补充 BudgetRatio 个，预算耗尽时不再对冲或重试，
+// 避免在节点故障时成倍放大请求量。
+// 备用节点通过 FromPeer 识别来自其他节点的请求并在本地加载，对冲请求不会被转发回缓慢的拥有者
+type HedgeConfig struct {
+	// Percentile 为对冲延迟所取的节点延迟分位数，如 0.95；为 0 时只使用 Delay
+	Percentile float64
+	// Delay 为样本不足或 Percentile 为 0 时的对冲延迟，为 0 时不对冲
+	Delay time.Duration
+	// MaxRetries 为每个节点遇到临时性错误时的最大重试次数
+	MaxRetries int
+	// RetryBackoff 为首次重试前的等待时间，每次重试翻倍
+	RetryBackoff time.Duration
+	// BudgetRatio 为每次加载补充的预算，如 0.1 表示对冲与重试最多占请求量的 10%；
+	// 为 0 时使用 defaultBudgetRatio
+	BudgetRatio float64
+}
+
+// GroupOption configures a Group.
+type GroupOption func(*Group)
+
+// WithHedging enables hedged and retried requests to peers.
+func WithHedging(cfg HedgeConfig) GroupOption {
+	return func(g *Group) {
+		if cfg.RetryBackoff <= 0 {
+			cfg.RetryBackoff = defaultRetryBackoff
+		}
+		if cfg.BudgetRatio <= 0 {
+			cfg.BudgetRatio = defaultBudgetRatio
+		}
+		g.hedge = &hedger{
+			cfg:    cfg,
+			budget: retryBudget{tokens: defaultBudgetTokens, max: defaultBudgetTokens, ratio: cfg.BudgetRatio},
+		}
+	}
+}
+
+// hedger 记录节点延迟与重试预算
+type hedger struct {
+	cfg     HedgeConfig
+	budget  retryBudget
+	mu      sync.Mutex
+	samples []time.Duration // 环形缓冲区
+	next    int
+}
+
+// observe records the latency of a successful peer request.
+func (h *hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < latencySamples {
+		h.samples = append(h.samples, d)
+		return
+	}
+	h.samples[h.next] = d
+	h.next = (h.next + 1) % latencySamples
+}
+
+// delay returns how long to wait before hedging, 0 means never.
+func (h *hedger) delay() time.Duration {
+	if h.cfg.Percentile <= 0 {
+		return h.cfg.Delay
+	}
+	h.mu.Lock()
+	if len(h.samples) < minLatencySamples {
+		h.mu.Unlock()
+		return h.cfg.Delay
+	}
+	samples := append([]time.Duration(nil), h.samples...)
+	h.mu.Unlock()
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	idx := int(h.cfg.Percentile * float64(len(samples)))
+	if idx >= len(samples) {
+		idx = len(samples) - 1
+	}
+	return samples[idx]
+}
+
+// retryBudget is a token bucket limiting hedges and retries.
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	ratio  float64
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens += b.ratio; b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// isTransient reports whether an error from a peer is worth retrying,
+// i.e. it implements Temporary() or Timeout() returning true.
+func isTransient(err error) bool {
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// attempt 为一次加载尝试，从某个远程节点或本地加载
+type attempt func(ctx context.Context) (ByteView, error)
+
+// race runs attempts in order and returns the first successful result.
+// 前一个尝试失败时立即开始下一个；超过对冲延迟仍未返回且预算允许时，提前开始下一个。
+// 返回时取消其余仍在进行的尝试
+func (g *Group) race(ctx context.Context, attempts []attempt) (ByteView, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		value ByteView
+		err   error
+	}
+	results := make(chan result, len(attempts))
+	start := func(a attempt) {
+		go func() {
+			value, err := a(ctx)
+			results <- result{value, err}
+		}()
+	}
+
+	var delay time.Duration
+	if g.hedge != nil {
+		g.hedge.budget.deposit()
+		delay = g.hedge.delay()
+	}
+
+	var (
+		next     int
+		inflight int
+		lastErr  error
+	)
+	for {
+		if inflight == 0 {
+			if next == len(attempts) || ctx.Err() != nil {
+				if lastErr == nil {
+					lastErr = ctx.Err()
+				}
+				return ByteView{}, lastErr
+			}
+			start(attempts[next])
+			next++
+			inflight++
+		}
+
+		var (
+			timer *time.Timer
+			hedge <-chan time.Time
+		)
+		if delay > 0 && next < len(attempts) {
+			timer = time.NewTimer(delay)
+			hedge = timer.C
+		}
+		select {
+		case r := <-results:
+			inflight--
+			if r.err == nil {
+				return r.value, nil
+			}
+			lastErr = r.err
+		case <-hedge:
+			if g.hedge.budget.withdraw() {
+				g.stats.PeerHedges.Add(1)
+				start(attempts[next])
+				next++
+				inflight++
+			} else {
+				// 预算耗尽，本次加载不再对冲
+				delay = 0
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// getFromPeerWithRetry 从远程节点获取，遇到临时性错误时在预算允许的情况下重试
+func (g *Group) getFromPeerWithRetry(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
+	backoff := time.Duration(0)
+	if g.hedge != nil {
+		backoff = g.hedge.cfg.RetryBackoff
+	}
+	for retries := 0; ; retries++ {
+		start := time.Now()
+		value, err := g.getFromPeer(ctx, peer, key)
+		if err == nil {
+			if g.hedge != nil {
+				g.hedge.observe(time.Since(start))
+			}
+			return value, nil
+		}
+		if g.hedge == nil || retries >= g.hedge.cfg.MaxRetries || ctx.Err() != nil ||
+			!isTransient(err) || !g.hedge.budget.withdraw() {
+			return ByteView{}, err
+		}
+		g.stats.PeerRetries.Add(1)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ByteView{}, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
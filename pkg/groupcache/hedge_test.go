@@ -0,0 +1,125 @@
+package groupcache
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func slowPeer(d time.Duration, value string) *fakePeer {
+	return &fakePeer{get: func(ctx context.Context, key string) ([]byte, error) {
+		select {
+		case <-time.After(d):
+			return []byte(value), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}}
+}
+
+type temporaryErr struct{}
+
+func (temporaryErr) Error() string   { return "temporary" }
+func (temporaryErr) Temporary() bool { return true }
+
+func TestHedgeToSecondary(t *testing.T) {
+	mem := NewGroup("hedge", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s should be fetched from peer", key)
+		}), WithHedging(HedgeConfig{Delay: 10 * time.Millisecond, BudgetRatio: 1}))
+	mem.RegisterPeers(&fakeListPicker{peers: []*fakePeer{slowPeer(time.Second, "primary"), slowPeer(0, "secondary")}})
+
+	start := time.Now()
+	view, err := mem.Get("Tom")
+	if err != nil || view.String() != "secondary" {
+		t.Fatalf("hedged request should return secondary, got %v %v", view, err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("hedged request should not wait for slow primary")
+	}
+	if mem.Stats().PeerHedges != 1 {
+		t.Fatalf("expect 1 hedge, got %d", mem.Stats().PeerHedges)
+	}
+}
+
+func TestHedgeToLocal(t *testing.T) {
+	mem := NewGroup("hedge-local", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte("local"), nil
+		}), WithHedging(HedgeConfig{Delay: 10 * time.Millisecond, BudgetRatio: 1}))
+	mem.RegisterPeers(&fakeListPicker{peers: []*fakePeer{slowPeer(time.Second, "primary")}})
+
+	if view, err := mem.Get("Tom"); err != nil || view.String() != "local" {
+		t.Fatalf("hedged request should load locally, got %v %v", view, err)
+	}
+}
+
+func TestNoHedgeWithoutConfig(t *testing.T) {
+	mem := NewGroup("no-hedge", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte("local"), nil
+		}))
+	mem.RegisterPeers(&fakeListPicker{peers: []*fakePeer{slowPeer(50*time.Millisecond, "primary")}})
+
+	if view, err := mem.Get("Tom"); err != nil || view.String() != "primary" {
+		t.Fatalf("should wait for primary without hedging, got %v %v", view, err)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	var calls int32
+	mem := NewGroup("retry", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte("local"), nil
+		}), WithHedging(HedgeConfig{MaxRetries: 100, RetryBackoff: time.Microsecond}))
+	peer := &fakePeer{get: func(ctx context.Context, key string) ([]byte, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return nil, temporaryErr{}
+		}
+		if key == "Tom" {
+			return []byte("peer"), nil
+		}
+		return nil, temporaryErr{}
+	}}
+	mem.RegisterPeers(&fakeListPicker{peers: []*fakePeer{peer}})
+
+	if view, err := mem.Get("Tom"); err != nil || view.String() != "peer" {
+		t.Fatalf("transient error should be retried, got %v %v", view, err)
+	}
+	if mem.Stats().PeerRetries != 1 {
+		t.Fatalf("expect 1 retry, got %d", mem.Stats().PeerRetries)
+	}
+
+	// 预算耗尽后不再重试，每次加载只补充 defaultBudgetRatio 个
+	if view, err := mem.Get("Jack"); err != nil || view.String() != "local" {
+		t.Fatalf("should load locally after retries, got %v %v", view, err)
+	}
+	if retries := mem.Stats().PeerRetries; retries != defaultBudgetTokens {
+		t.Fatalf("retries should be limited by budget %d, got %d", defaultBudgetTokens, retries)
+	}
+}
+
+func TestHedgeDefaultBudgetRatio(t *testing.T) {
+	mem := NewGroup("hedge-default", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte("local"), nil
+		}), WithHedging(HedgeConfig{Delay: 10 * time.Millisecond}))
+	if ratio := mem.hedge.budget.ratio; ratio != defaultBudgetRatio {
+		t.Fatalf("zero BudgetRatio should default to %v, got %v", defaultBudgetRatio, ratio)
+	}
+}
+
+func TestHedgeDelayPercentile(t *testing.T) {
+	h := &hedger{cfg: HedgeConfig{Percentile: 0.9, Delay: time.Second}}
+	if d := h.delay(); d != time.Second {
+		t.Fatalf("delay should fall back to Delay without samples, got %v", d)
+	}
+	for i := 1; i <= 100; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	if d := h.delay(); d != 91*time.Millisecond {
+		t.Fatalf("p90 delay should be 91ms, got %v", d)
+	}
+}
@@ -1,6 +1,10 @@
 package groupcache
 
-import "github.com/fusidic/FuCache/proto/cachepb"
+import (
+	"context"
+
+	"github.com/fusidic/FuCache/proto/cachepb"
+)
 
 // PeerPicker is the interface that must be implemented to
 // locate the peer that owns a specific key
@@ -9,10 +13,65 @@ type PeerPicker interface {
 	PickPeer(key string) (peer PeerGetter, ok bool)
 }
 
+// PeerListPicker is a PeerPicker which can pick the owner of a key
+// followed by fallback peers.
+// Group 会依次尝试列表中的节点，全部失败后才从本地加载
+type PeerListPicker interface {
+	PeerPicker
+	// PickPeers 返回按优先级排序的远程节点，遇到自身时截止；
+	// 返回空列表表示自身为 key 的拥有者
+	PickPeers(key string) []PeerGetter
+}
+
+// OwnerPicker is a PeerPicker which can tell whether this node owns a key without
+// side effects, unlike picking which may count the load of bounded-load placement.
+// 后台刷新据此只刷新本节点拥有的 key；未实现时使用 PickPeer 判断
+type OwnerPicker interface {
+	PeerPicker
+	// IsOwner 返回本节点是否为 key 在哈希环上的拥有者，不考虑节点的健康状况与负载
+	IsOwner(key string) bool
+}
+
+// ReplicaPicker is a PeerPicker which can list every peer that may hold a key:
+// its owner in the hash ring followed by the fallback peers.
+// 删除缓存时使用，不跳过故障或熔断中的节点，也不考虑有界负载，避免拥有者保留旧值；
+// 未实现时使用 PeerListPicker 或 PeerPicker 选择的节点
+type ReplicaPicker interface {
+	PeerPicker
+	// PickReplicas 返回可能缓存了 key 的远程节点，拥有者在前，不包括自身
+	PickReplicas(key string) []PeerGetter
+}
+
 // PeerGetter is the interface that must be implemented by a peer.
+// ctx 的取消与截止时间需要传递到远程节点
 type PeerGetter interface {
 	// 从对应 group 中查找缓存值
 	// Get(group string, key string) ([]byte, error)
 	// protobuf
-	Get(in *cachepb.Request, out *cachepb.Response) error
+	Get(ctx context.Context, in *cachepb.Request, out *cachepb.Response) error
+	// 从对应 group 中删除缓存值
+	Remove(ctx context.Context, in *cachepb.Request) error
+}
+
+// BatchPeerGetter is a PeerGetter which can get many keys of a group in one request.
+// 单个 key 的错误通过 BatchResponse_Result.Error 返回，返回 error 表示整个请求失败
+type BatchPeerGetter interface {
+	PeerGetter
+	GetMany(ctx context.Context, in *cachepb.BatchRequest, out *cachepb.BatchResponse) error
+}
+
+type fromPeerKey struct{}
+
+// FromPeer marks ctx as serving a request sent by another peer, which has already
+// picked this node as the owner or a fallback of the key.
+// 这类请求只从本地缓存或 Getter 加载，删除也只删除本地缓存，不再转发给其他节点；
+// 避免备用节点与对冲请求被转发回故障或缓慢的拥有者，也避免节点视图不一致时循环转发
+func FromPeer(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fromPeerKey{}, true)
+}
+
+// isFromPeer reports whether ctx is marked by FromPeer.
+func isFromPeer(ctx context.Context) bool {
+	fromPeer, _ := ctx.Value(fromPeerKey{}).(bool)
+	return fromPeer
 }
@@ -0,0 +1,126 @@
+package groupcache
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// WithRefreshAhead reloads a key owned by this node in the background when a cache hit
+// finds it expiring within ahead, the current value keeps being served meanwhile.
+// 后台加载经过 loader，与同一个 key 的未命中请求合并；永不过期的值不会被刷新
+func WithRefreshAhead(ahead time.Duration) GroupOption {
+	return func(g *Group) {
+		if ahead > 0 {
+			g.refresh = &refresher{ahead: ahead, inflight: make(map[string]struct{})}
+		}
+	}
+}
+
+// WithStaleIfError keeps the last good value of each key loaded by this node,
+// and serves it when loading the key fails, for at most maxStale after it expired.
+// maxStale 为 0 表示不限制；这些值保存在容量为 maxBytes 的独立缓存中，不计入 Group 的 cacheBytes，
+// 因此 Group 最多占用 cacheBytes + maxBytes；maxBytes 为 0 表示不限制
+func WithStaleIfError(maxStale time.Duration, maxBytes int64) GroupOption {
+	return func(g *Group) {
+		g.stale = &staleCache{maxStale: maxStale, cache: cache{cacheBytes: maxBytes}}
+	}
+}
+
+// refresher 记录正在后台刷新的 key，避免每次命中都启动一个 goroutine
+type refresher struct {
+	ahead time.Duration
+
+	mu       sync.Mutex
+	inflight map[string]struct{}
+}
+
+// start 标记 key 正在刷新，已在刷新时返回 false
+func (r *refresher) start(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.inflight[key]; ok {
+		return false
+	}
+	r.inflight[key] = struct{}{}
+	return true
+}
+
+func (r *refresher) done(key string) {
+	r.mu.Lock()
+	delete(r.inflight, key)
+	r.mu.Unlock()
+}
+
+// maybeRefresh 在命中的值即将过期时于后台重新加载，只刷新本节点拥有的 key
+func (g *Group) maybeRefresh(key string, value ByteView) {
+	if g.refresh == nil || value.e.IsZero() || time.Until(value.e) > g.refresh.ahead {
+		return
+	}
+	// 刷新进行中时不再判断拥有者，判断只在每次刷新开始时进行一次
+	if !g.refresh.start(key) {
+		return
+	}
+	if !g.ownsKey(key) {
+		g.refresh.done(key)
+		return
+	}
+	g.stats.Refreshes.Add(1)
+	go func() {
+		defer g.refresh.done(key)
+		_, err := g.loader.Do(key, func() (interface{}, error) {
+			g.stats.LoadsDeduped.Add(1)
+			value, err := g.getLocally(context.Background(), key)
+			if err != nil {
+				g.stats.LocalLoadErrs.Add(1)
+				return ByteView{}, err
+			}
+			g.stats.LocalLoads.Add(1)
+			return value, nil
+		})
+		if err != nil {
+			log.Println("[GroupCache] Failed to refresh", key, err)
+		}
+	}()
+}
+
+// ownsKey 判断本节点是否拥有 key，与 pickPeers 不同，不会影响节点的负载计数
+func (g *Group) ownsKey(key string) bool {
+	switch peers := g.peers.(type) {
+	case nil:
+		return true
+	case OwnerPicker:
+		return peers.IsOwner(key)
+	default:
+		_, ok := peers.PickPeer(key)
+		return !ok
+	}
+}
+
+// staleCache 保存本节点加载的最后一个有效值
+type staleCache struct {
+	maxStale time.Duration
+	cache    cache
+}
+
+func (s *staleCache) add(key string, value ByteView) {
+	var expire time.Time
+	if s.maxStale > 0 && !value.e.IsZero() {
+		expire = value.e.Add(s.maxStale)
+	}
+	s.cache.addWithExpire(key, value, expire)
+}
+
+// staleOnError 在加载 key 失败时返回其最后一个有效值
+func (g *Group) staleOnError(key string, err error) (ByteView, bool) {
+	if g.stale == nil {
+		return ByteView{}, false
+	}
+	v, ok := g.stale.cache.get(key)
+	if ok {
+		g.stats.StaleHits.Add(1)
+		log.Println("[GroupCache] Serving stale value of", key, err)
+	}
+	return v, ok
+}
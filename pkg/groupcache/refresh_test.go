@@ -0,0 +1,162 @@
+package groupcache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshAhead(t *testing.T) {
+	var version int32
+	g := NewGroup("refresh-ahead", 2<<10, ExpiringGetterFunc(
+		func(key string) ([]byte, time.Time, error) {
+			n := atomic.AddInt32(&version, 1)
+			// 让后台刷新持续一段时间，期间的命中不会再次触发刷新
+			if n > 1 {
+				time.Sleep(50 * time.Millisecond)
+			}
+			return []byte(fmt.Sprintf("%s-%d", key, n)), time.Now().Add(time.Second), nil
+		}), WithRefreshAhead(900*time.Millisecond))
+
+	if v, err := g.Get("Tom"); err != nil || v.String() != "Tom-1" {
+		t.Fatalf("Get(Tom) = %q, %v", v.String(), err)
+	}
+	g.Get("Tom")
+	if s := g.Stats(); s.Refreshes != 0 {
+		t.Fatalf("fresh value should not be refreshed, stats %+v", s)
+	}
+
+	// 剩余时间进入 ahead 后，命中依旧返回当前值，并只触发一次后台刷新
+	time.Sleep(200 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := g.Get("Tom"); err != nil || v.String() != "Tom-1" {
+				t.Errorf("Get(Tom) during refresh = %q, %v", v.String(), err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := g.Get("Tom"); v.String() == "Tom-2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("value should be refreshed in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := g.Stats(); s.Refreshes != 1 || atomic.LoadInt32(&version) != 2 {
+		t.Fatalf("expect 1 refresh, got stats %+v and %d loads", s, version)
+	}
+}
+
+// ownerPicker 记录节点选择与拥有者判断的次数，owned 中的 key 由本节点拥有
+type ownerPicker struct {
+	owned        map[string]bool
+	picks, owner int32
+}
+
+func (p *ownerPicker) PickPeer(key string) (PeerGetter, bool) {
+	atomic.AddInt32(&p.picks, 1)
+	if p.owned[key] {
+		return nil, false
+	}
+	return &fakePeer{}, true
+}
+
+func (p *ownerPicker) IsOwner(key string) bool {
+	atomic.AddInt32(&p.owner, 1)
+	return p.owned[key]
+}
+
+func TestRefreshAheadOwnership(t *testing.T) {
+	release := make(chan struct{})
+	var loads int32
+	g := NewGroup("refresh-ahead-owner", 2<<10, ExpiringGetterFunc(
+		func(key string) ([]byte, time.Time, error) {
+			if atomic.AddInt32(&loads, 1) > 1 {
+				<-release
+			}
+			return []byte(key), time.Now().Add(time.Hour), nil
+		}), WithRefreshAhead(2*time.Hour))
+	picker := &ownerPicker{owned: map[string]bool{"Tom": true}}
+	g.RegisterPeers(picker)
+
+	g.Get("Tom")
+	// 刷新进行中的命中不再判断拥有者，也不会选择节点
+	for i := 0; i < 10; i++ {
+		g.Get("Tom")
+	}
+	close(release)
+	if picks, owner := atomic.LoadInt32(&picker.picks), atomic.LoadInt32(&picker.owner); picks != 1 || owner != 1 {
+		t.Fatalf("expect 1 pick for the miss and 1 ownership check, got %d and %d", picks, owner)
+	}
+	if s := g.Stats(); s.Refreshes != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	var fail int32
+	getter := ExpiringGetterFunc(func(key string) ([]byte, time.Time, error) {
+		if atomic.LoadInt32(&fail) == 1 {
+			return nil, time.Time{}, errors.New("source unavailable")
+		}
+		if v, ok := db[key]; ok {
+			return []byte(v), time.Now().Add(20 * time.Millisecond), nil
+		}
+		return nil, time.Time{}, fmt.Errorf("%s not exist", key)
+	})
+	g := NewGroup("stale-if-error", 2<<10, getter, WithStaleIfError(time.Hour, 0))
+	short := NewGroup("stale-if-error-short", 2<<10, getter, WithStaleIfError(10*time.Millisecond, 0))
+
+	g.Get("Tom")
+	short.Get("Tom")
+	atomic.StoreInt32(&fail, 1)
+	time.Sleep(50 * time.Millisecond)
+
+	// 值已过期且加载失败，返回最后一个有效值
+	if v, err := g.Get("Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("Get(Tom) = %q, %v, expect stale value", v.String(), err)
+	}
+	if s := g.Stats(); s.StaleHits != 1 || s.LocalLoadErrs != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if r := g.GetMany([]string{"Tom"}); r[0].Err != nil || r[0].Value.String() != "630" {
+		t.Fatalf("GetMany should also serve stale value, got %+v", r[0])
+	}
+	if _, err := g.Get("Jack"); err == nil {
+		t.Fatalf("key never loaded should fail")
+	}
+	// 超出 maxStale 的值不再返回
+	if _, err := short.Get("Tom"); err == nil {
+		t.Fatalf("value stale for longer than maxStale should not be served")
+	}
+	// 删除后不再返回旧值
+	g.Remove("Tom")
+	if _, err := g.Get("Tom"); err == nil {
+		t.Fatalf("removed key should not be served stale")
+	}
+}
+
+func TestStaleIfErrorMaxBytes(t *testing.T) {
+	g := NewGroup("stale-if-error-max-bytes", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}), WithStaleIfError(time.Hour, 10))
+
+	g.Get("Tom")
+	g.Get("Jack")
+	// 旧值缓存有独立的容量，不影响 mainCache
+	s := g.Stats()
+	if s.MainCache.Items != 2 || s.StaleCache.Items != 1 || s.StaleCache.Bytes > 10 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
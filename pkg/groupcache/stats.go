@@ -0,0 +1,108 @@
+package groupcache
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// AtomicInt is an int64 to be accessed atomically.
+type AtomicInt int64
+
+// Add atomically adds n to i.
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+// Get atomically gets the value of i.
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// groupStats are per-group counters, updated atomically.
+type groupStats struct {
+	Gets           AtomicInt // 所有 Get 请求，包括来自远程节点的请求
+	CacheHits      AtomicInt // mainCache 或 hotCache 命中
+	PeerLoads      AtomicInt // 从远程节点获取成功
+	PeerErrors     AtomicInt // 从远程节点获取失败
+	PeerHedges     AtomicInt // 对冲请求
+	PeerRetries    AtomicInt // 遇到临时性错误后的重试
+	Loads          AtomicInt // 未命中缓存，即 Gets - CacheHits
+	LoadsDeduped   AtomicInt // 经过 singleflight 去重后真正执行的加载
+	LocalLoads     AtomicInt // 从 Getter 加载成功
+	LocalLoadErrs  AtomicInt // 从 Getter 加载失败
+	LocalBatches   AtomicInt // 调用 BatchGetter.GetMany 的次数
+	Refreshes      AtomicInt // 值即将过期时启动的后台刷新
+	StaleHits      AtomicInt // 加载失败时返回的最后一个有效值
+	ServerRequests AtomicInt // 来自远程节点的 Get 请求
+}
+
+// Stats is a snapshot of the statistics of a Group.
+type Stats struct {
+	Gets           int64      `json:"gets"`
+	CacheHits      int64      `json:"cache_hits"`
+	PeerLoads      int64      `json:"peer_loads"`
+	PeerErrors     int64      `json:"peer_errors"`
+	PeerHedges     int64      `json:"peer_hedges"`
+	PeerRetries    int64      `json:"peer_retries"`
+	Loads          int64      `json:"loads"`
+	LoadsDeduped   int64      `json:"loads_deduped"`
+	LocalLoads     int64      `json:"local_loads"`
+	LocalLoadErrs  int64      `json:"local_load_errs"`
+	LocalBatches   int64      `json:"local_batches"`
+	Refreshes      int64      `json:"refreshes"`
+	StaleHits      int64      `json:"stale_hits"`
+	ServerRequests int64      `json:"server_requests"`
+	MainCache      CacheStats `json:"main_cache"`
+	HotCache       CacheStats `json:"hot_cache"`
+	StaleCache     CacheStats `json:"stale_cache"` // 未启用 WithStaleIfError 时为零值
+}
+
+// CacheStats is a snapshot of the statistics of a cache.
+type CacheStats struct {
+	Bytes       int64 `json:"bytes"`
+	Items       int64 `json:"items"`
+	Gets        int64 `json:"gets"`
+	Hits        int64 `json:"hits"`
+	Evictions   int64 `json:"evictions"`   // 因容量不足被淘汰
+	Expirations int64 `json:"expirations"` // 过期被删除
+}
+
+// Stats returns a snapshot of the statistics of the group and its caches.
+func (g *Group) Stats() Stats {
+	return Stats{
+		Gets:           g.stats.Gets.Get(),
+		CacheHits:      g.stats.CacheHits.Get(),
+		PeerLoads:      g.stats.PeerLoads.Get(),
+		PeerErrors:     g.stats.PeerErrors.Get(),
+		PeerHedges:     g.stats.PeerHedges.Get(),
+		PeerRetries:    g.stats.PeerRetries.Get(),
+		Loads:          g.stats.Loads.Get(),
+		LoadsDeduped:   g.stats.LoadsDeduped.Get(),
+		LocalLoads:     g.stats.LocalLoads.Get(),
+		LocalLoadErrs:  g.stats.LocalLoadErrs.Get(),
+		LocalBatches:   g.stats.LocalBatches.Get(),
+		Refreshes:      g.stats.Refreshes.Get(),
+		StaleHits:      g.stats.StaleHits.Get(),
+		ServerRequests: g.stats.ServerRequests.Get(),
+		MainCache:      g.mainCache.stats(),
+		HotCache:       g.hotCache.stats(),
+		StaleCache:     g.staleStats(),
+	}
+}
+
+func (g *Group) staleStats() CacheStats {
+	if g.stale == nil {
+		return CacheStats{}
+	}
+	return g.stale.cache.stats()
+}
+
+// RecordServerRequest counts a Get request that came over the network from peers,
+// it should be called by the server serving peers.
+func (g *Group) RecordServerRequest() {
+	g.stats.ServerRequests.Add(1)
+}
@@ -1,25 +1,40 @@
 package lru
 
-import "container/list"
+import (
+	"container/heap"
+	"container/list"
+	"time"
+)
 
 // Cache is a LRU cache, not safe for concurrent access.
 // maxBytes indicate the maximum storage capability.
 // nbytes indicate the current used storage.
 // ll is a two-wat linked list to store all data.
 // cache is a map for getting data in linked list, cache's value is the pointer of ll.Element
+// expiry is a min-heap of entries ordered by expiration, only entries with a deadline are in it.
 // OnEvicted is a handler for node being evicted which can be nil.
 type Cache struct {
 	maxBytes int64
 	nbytes   int64
 	ll       *list.List
 	cache    map[string]*list.Element
+	expiry   expiryHeap
 	// optional and executed when an entry is purged.
 	OnEvicted func(key string, value Value)
+	// now 用于获取当前时间，测试时可替换
+	now func() time.Time
 }
 
 type entry struct {
-	key   string
-	value Value
+	key    string
+	value  Value
+	expire time.Time // 零值表示永不过期
+	index  int       // 在 expiry 堆中的下标，-1 表示不在堆中
+}
+
+// expired reports whether the entry has a deadline that is not after now.
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && !now.Before(e.expire)
 }
 
 // Value use Len to count how many bytes it takes.
@@ -34,14 +49,20 @@ func NewLRU(maxBytes int64, onEvicted func(string, Value)) *Cache {
 		ll:        list.New(),
 		cache:     make(map[string]*list.Element),
 		OnEvicted: onEvicted,
+		now:       time.Now,
 	}
 }
 
 // Get looks up a key's value.
+// 已过期的节点视为未命中，并会被直接删除
 func (c *Cache) Get(key string) (value Value, ok bool) {
 	if ele, ok := c.cache[key]; ok {
-		c.ll.MoveToFront(ele)
 		kv := ele.Value.(*entry)
+		if kv.expired(c.now()) {
+			c.removeElement(ele)
+			return nil, false
+		}
+		c.ll.MoveToFront(ele)
 		return kv.value, true
 	}
 	return
@@ -51,18 +72,49 @@ func (c *Cache) Get(key string) (value Value, ok bool) {
 func (c *Cache) RemoveOldest() {
 	ele := c.ll.Back()
 	if ele != nil {
-		c.ll.Remove(ele)
-		kv := ele.Value.(*entry)
-		delete(c.cache, kv.key)
-		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value)
-		}
+		c.removeElement(ele)
+	}
+}
+
+// Remove removes the provided key from the cache.
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+	}
+}
+
+// RemoveExpired removes all items whose deadline has passed.
+// 通过过期堆按截止时间从早到晚回收，不必等到容量不足
+func (c *Cache) RemoveExpired() {
+	now := c.now()
+	for len(c.expiry) > 0 && c.expiry[0].expired(now) {
+		c.removeElement(c.cache[c.expiry[0].key])
+	}
+}
+
+func (c *Cache) removeElement(ele *list.Element) {
+	c.ll.Remove(ele)
+	kv := ele.Value.(*entry)
+	delete(c.cache, kv.key)
+	if kv.index >= 0 {
+		heap.Remove(&c.expiry, kv.index)
+	}
+	c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
 	}
 }
 
 // Add insert/update a value in cache
 func (c *Cache) Add(key string, value Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire insert/update a value in cache which expires at the given time.
+// A zero expire means the value never expires.
+func (c *Cache) AddWithExpire(key string, value Value, expire time.Time) {
+	// 先回收已过期的节点，腾出空间
+	c.RemoveExpired()
 	if ele, ok := c.cache[key]; ok {
 		// 节点已经存在
 		c.ll.MoveToFront(ele)
@@ -70,11 +122,14 @@ func (c *Cache) Add(key string, value Value) {
 		// 节点可能出现变化
 		c.nbytes += int64(value.Len()) - int64(kv.value.Len())
 		kv.value = value
+		c.setExpire(kv, expire)
 	} else {
 		// 节点还不存在
-		ele := c.ll.PushFront(&entry{key, value})
+		kv := &entry{key: key, value: value, index: -1}
+		ele := c.ll.PushFront(kv)
 		c.cache[key] = ele
 		c.nbytes += int64(len(key)) + int64(value.Len())
+		c.setExpire(kv, expire)
 	}
 	// 缓存满，删除队首使用频率最低的节点
 	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
@@ -82,7 +137,55 @@ func (c *Cache) Add(key string, value Value) {
 	}
 }
 
-// Len the number of cache entries, test only
+// setExpire updates the deadline of kv and keeps the expiry heap in order.
+func (c *Cache) setExpire(kv *entry, expire time.Time) {
+	kv.expire = expire
+	switch {
+	case expire.IsZero() && kv.index >= 0:
+		heap.Remove(&c.expiry, kv.index)
+	case expire.IsZero():
+	case kv.index >= 0:
+		heap.Fix(&c.expiry, kv.index)
+	default:
+		heap.Push(&c.expiry, kv)
+	}
+}
+
+// Bytes returns the bytes used by keys and values in the cache.
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
+
+// Len the number of cache entries.
 func (c *Cache) Len() int {
 	return c.ll.Len()
 }
+
+// expiryHeap implements heap.Interface, the earliest deadline is at the top.
+type expiryHeap []*entry
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool { return h[i].expire.Before(h[j].expire) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	kv := x.(*entry)
+	kv.index = len(*h)
+	*h = append(*h, kv)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	kv := old[n-1]
+	old[n-1] = nil
+	kv.index = -1
+	*h = old[:n-1]
+	return kv
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type String string
@@ -55,3 +56,47 @@ func TestOnEvicted(t *testing.T) {
 		log.Printf("%s", expect)
 	}
 }
+
+func TestExpire(t *testing.T) {
+	now := time.Now()
+	evicted := make([]string, 0)
+	lru := NewLRU(int64(0), func(key string, value Value) {
+		evicted = append(evicted, key)
+	})
+	lru.now = func() time.Time { return now }
+	lru.AddWithExpire("key1", String("1"), now.Add(time.Second))
+	lru.AddWithExpire("key2", String("2"), now.Add(2*time.Second))
+	lru.Add("key3", String("3"))
+
+	if _, ok := lru.Get("key1"); !ok {
+		t.Fatalf("key1 should not expire yet")
+	}
+
+	now = now.Add(time.Second)
+	if _, ok := lru.Get("key1"); ok {
+		t.Fatalf("key1 should be expired")
+	}
+
+	// key2 过期后应当在下一次 Add 时被主动回收
+	now = now.Add(time.Second)
+	lru.Add("key4", String("4"))
+	if lru.Len() != 2 {
+		t.Fatalf("expired entries are not reclaimed, len %d", lru.Len())
+	}
+	expect := []string{"key1", "key2"}
+	if !reflect.DeepEqual(expect, evicted) {
+		t.Fatalf("evicted keys %v, expect %v", evicted, expect)
+	}
+	if _, ok := lru.Get("key3"); !ok {
+		t.Fatalf("key3 without expire should never expire")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	lru := NewLRU(int64(0), nil)
+	lru.AddWithExpire("key1", String("1234"), time.Now().Add(time.Hour))
+	lru.Remove("key1")
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 0 || lru.nbytes != 0 || len(lru.expiry) != 0 {
+		t.Fatalf("Remove key1 failed")
+	}
+}
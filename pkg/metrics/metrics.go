@@ -0,0 +1,172 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the default upper bounds in seconds for request latency.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Label is a name/value pair attached to a sample.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Histogram counts observations into buckets, safe for concurrent access.
+// counts[i] 为落入 (buckets[i-1], buckets[i]] 的观测次数，最后一位对应 +Inf
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// NewHistogram creates a Histogram with sorted upper bounds,
+// DefaultBuckets is used if buckets is nil.
+func NewHistogram(buckets []float64) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// Observe adds a single observation.
+func (h *Histogram) Observe(v float64) {
+	idx := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	h.counts[idx]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// ObserveSince adds the seconds elapsed since start.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// write renders the histogram samples with cumulative buckets.
+func (h *Histogram) write(w io.Writer, name string, labels []Label) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	var cumulative uint64
+	for i, upper := range h.buckets {
+		cumulative += counts[i]
+		le := Label{"le", strconv.FormatFloat(upper, 'g', -1, 64)}
+		WriteSample(w, name+"_bucket", append(labels, le), float64(cumulative))
+	}
+	WriteSample(w, name+"_bucket", append(labels, Label{"le", "+Inf"}), float64(count))
+	WriteSample(w, name+"_sum", labels, sum)
+	WriteSample(w, name+"_count", labels, float64(count))
+}
+
+// HistogramVec is a set of Histograms partitioned by the value of one label.
+type HistogramVec struct {
+	name    string
+	help    string
+	label   string
+	buckets []float64
+	mu      sync.Mutex
+	hs      map[string]*Histogram
+}
+
+// NewHistogramVec creates a HistogramVec partitioned by label.
+func NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	return &HistogramVec{
+		name:    name,
+		help:    help,
+		label:   label,
+		buckets: buckets,
+		hs:      make(map[string]*Histogram),
+	}
+}
+
+// With returns the Histogram for the label value, creating it if needed.
+func (v *HistogramVec) With(value string) *Histogram {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	h, ok := v.hs[value]
+	if !ok {
+		h = NewHistogram(v.buckets)
+		v.hs[value] = h
+	}
+	return h
+}
+
+// Delete removes the Histogram for the label value so it is no longer written.
+func (v *HistogramVec) Delete(value string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.hs, value)
+}
+
+// Write renders all histograms of the vector in the text exposition format.
+func (v *HistogramVec) Write(w io.Writer) {
+	v.mu.Lock()
+	values := make([]string, 0, len(v.hs))
+	hs := make(map[string]*Histogram, len(v.hs))
+	for value, h := range v.hs {
+		values = append(values, value)
+		hs[value] = h
+	}
+	v.mu.Unlock()
+	sort.Strings(values)
+
+	WriteHeader(w, v.name, v.help, "histogram")
+	for _, value := range values {
+		hs[value].write(w, v.name, []Label{{v.label, value}})
+	}
+}
+
+// WriteHeader writes the HELP and TYPE lines of a metric family.
+func WriteHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// WriteSample writes a single sample line, e.g. name{a="b"} 1
+func WriteSample(w io.Writer, name string, labels []Label, value float64) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "%s=\"%s\"", l.Name, escapeLabel(l.Value))
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	b.WriteByte('\n')
+	io.WriteString(w, b.String())
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
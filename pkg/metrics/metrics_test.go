@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHistogramVec(t *testing.T) {
+	v := NewHistogramVec("latency_seconds", "Request latency.", "peer", []float64{0.1, 1})
+	v.With("a").Observe(0.05)
+	v.With("a").Observe(0.5)
+	v.With("a").Observe(2)
+	v.With(`b"`).Observe(0.1)
+
+	var b strings.Builder
+	v.Write(&b)
+	expect := `# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{peer="a",le="0.1"} 1
+latency_seconds_bucket{peer="a",le="1"} 2
+latency_seconds_bucket{peer="a",le="+Inf"} 3
+latency_seconds_sum{peer="a"} 2.55
+latency_seconds_count{peer="a"} 3
+latency_seconds_bucket{peer="b\"",le="0.1"} 1
+latency_seconds_bucket{peer="b\"",le="1"} 1
+latency_seconds_bucket{peer="b\"",le="+Inf"} 1
+latency_seconds_sum{peer="b\""} 0.1
+latency_seconds_count{peer="b\""} 1
+`
+	if b.String() != expect {
+		t.Fatalf("unexpected output:\n%s", b.String())
+	}
+}
+
+func TestHistogramVecDelete(t *testing.T) {
+	v := NewHistogramVec("latency_seconds", "Request latency.", "peer", []float64{0.1, 1})
+	v.With("a").Observe(0.05)
+	v.With("b").Observe(0.05)
+	v.Delete("a")
+
+	var b strings.Builder
+	v.Write(&b)
+	if strings.Contains(b.String(), `peer="a"`) || !strings.Contains(b.String(), `peer="b"`) {
+		t.Fatalf("deleted series should not be written:\n%s", b.String())
+	}
+}
@@ -1,12 +1,21 @@
 package singleflight
 
-import "sync"
+import (
+	"context"
+	"sync"
+	"time"
+)
 
-// call 代表正在进行中，或已经结束的请求，使用 sync.WaitGroup 锁避免重入
+// call 代表正在进行中，或已经结束的请求，done 关闭后 val 与 err 可读
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	done chan struct{}
+	val  interface{}
+	err  error
+
+	// waiters 为等待结果的调用方数量，归零时通过 cancel 取消 ctx
+	waiters int
+	ctx     context.Context
+	cancel  context.CancelFunc
 }
 
 // Group is the basic data structure of singleflight.
@@ -18,27 +27,97 @@ type Group struct {
 // Do 接收 key 与函数 fn
 // 无论 Do 被调用多少次，函数 fn 只会被调用一次
 func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	return g.DoJoin(key, fn, nil)
+}
+
+// DoJoin 与 Do 相同，key 已有进行中的请求时，在等待其结果之前调用 joined
+// 调用方可以据此得知 fn 不会被本次调用执行
+func (g *Group) DoJoin(key string, fn func() (interface{}, error), joined func()) (interface{}, error) {
+	c, leader := g.join(key, context.Background())
+	if !leader {
+		if joined != nil {
+			joined()
+		}
+		<-c.done // 如果请求正在进行，则等待
+		return c.val, c.err
+	}
+	g.finish(key, c, fn) // 对同样的 key 只进行一次调用
+	return c.val, c.err
+}
+
+// DoContext 与 DoJoin 相同，但 fn 在新的 goroutine 中以 ctx 的一个副本调用，
+// 调用方的 ctx 取消时直接返回 ctx.Err()。
+// fn 的 ctx 保留首个调用方 ctx 中的值，但不继承任何调用方的取消与截止时间：
+// 只有所有等待结果的调用方都离开后才会被取消，此后的调用会重新执行 fn
+func (g *Group) DoContext(ctx context.Context, key string, fn func(context.Context) (interface{}, error), joined func()) (interface{}, error) {
+	c, leader := g.join(key, ctx)
+	if leader {
+		go g.finish(key, c, func() (interface{}, error) {
+			return fn(c.ctx)
+		})
+	} else if joined != nil {
+		joined()
+	}
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		g.leave(key, c)
+		return nil, ctx.Err()
+	}
+}
+
+// join 将调用方加入 key 进行中的请求，没有时创建一个，此时 leader 为 true
+func (g *Group) join(key string, parent context.Context) (c *call, leader bool) {
 	g.mu.Lock() // m 的并发读写锁
+	defer g.mu.Unlock()
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
 	if c, ok := g.m[key]; ok {
-		g.mu.Unlock() // 释放 group 锁，其他 key 可以正常读取了
-		c.wg.Wait()   // 如果请求正在进行，则等待
-		return c.val, c.err
+		c.waiters++
+		return c, false
 	}
 	// 首次请求该 key
-	c := new(call)
-	c.wg.Add(1)  // 发起请求前加锁
+	c = &call{done: make(chan struct{}), waiters: 1}
+	c.ctx, c.cancel = context.WithCancel(valueOnly{parent})
 	g.m[key] = c // 添加到 g.m，表明 key 已经有对应的请求在处理
-	g.mu.Unlock()
-
-	c.val, c.err = fn() // 对同样的 key 只进行一次调用
-	c.wg.Done()
+	return c, true
+}
 
+// finish 执行 fn 并将结果交给所有等待的调用方
+func (g *Group) finish(key string, c *call, fn func() (interface{}, error)) {
+	c.val, c.err = fn()
 	g.mu.Lock()
-	delete(g.m, key) // 回收资源
+	if g.m[key] == c {
+		delete(g.m, key) // 回收资源
+	}
 	g.mu.Unlock()
+	close(c.done)
+	c.cancel()
+}
 
-	return c.val, c.err
+// leave 移除一个等待的调用方，最后一个离开时取消 fn 的 ctx
+func (g *Group) leave(key string, c *call) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c.waiters--; c.waiters > 0 {
+		return
+	}
+	// 已取消的请求不再被新的调用方加入
+	if g.m[key] == c {
+		delete(g.m, key)
+	}
+	c.cancel()
 }
+
+// valueOnly 保留父 ctx 的值，但不随其取消，也没有截止时间
+type valueOnly struct {
+	parent context.Context
+}
+
+func (valueOnly) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (valueOnly) Done() <-chan struct{}               { return nil }
+func (valueOnly) Err() error                          { return nil }
+func (c valueOnly) Value(key interface{}) interface{} { return c.parent.Value(key) }